@@ -0,0 +1,371 @@
+package sugo
+
+import (
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// aclNamespace is the storage namespace ACLs are kept in.
+const aclNamespace = "sugo.acl"
+
+// ACL contains per-guild access overrides of the command (and all of it's subcommands).
+type ACL struct {
+	// AllowRoles and AllowUsers grant access to the command regardless of it's RolesRequired and PermissionsRequired.
+	AllowRoles []string `json:"allow_roles,omitempty"`
+	AllowUsers []string `json:"allow_users,omitempty"`
+	// AllowChannels restricts the command to the given channels if not empty.
+	AllowChannels []string `json:"allow_channels,omitempty"`
+	// DenyRoles, DenyUsers and DenyChannels deny access to the command. Deny always wins over allow.
+	DenyRoles    []string `json:"deny_roles,omitempty"`
+	DenyUsers    []string `json:"deny_users,omitempty"`
+	DenyChannels []string `json:"deny_channels,omitempty"`
+}
+
+// Empty returns true if ACL has no entries.
+func (a *ACL) Empty() bool {
+	return len(a.AllowRoles)+len(a.AllowUsers)+len(a.AllowChannels)+
+		len(a.DenyRoles)+len(a.DenyUsers)+len(a.DenyChannels) == 0
+}
+
+// aclStore returns the store of the guild ACLs.
+func (sg *Instance) aclStore(guildID string) *Store {
+	store := sg.Store(aclNamespace)
+	store.prefix = guildStorePrefix(guildID)
+	return store
+}
+
+// GetACL returns the ACL of the command path in the guild. Empty ACL is returned if there is none.
+func (sg *Instance) GetACL(guildID string, path string) (*ACL, error) {
+	acl := &ACL{}
+	if _, err := sg.aclStore(guildID).GetJSON(path, acl); err != nil {
+		return nil, errors.Wrap(err, "unable to get acl of "+path)
+	}
+	return acl, nil
+}
+
+// SetACL sets the ACL of the command path in the guild. Setting empty ACL removes it.
+func (sg *Instance) SetACL(guildID string, path string, acl *ACL) error {
+	if acl.Empty() {
+		return sg.aclStore(guildID).Delete(path)
+	}
+	return sg.aclStore(guildID).SetJSON(path, acl, 0)
+}
+
+// memberRoles returns role IDs of the guild member. Roles are taken from the state if possible and requested from
+// discord otherwise.
+func (sg *Instance) memberRoles(guildID string, userID string) ([]string, error) {
+	member, err := sg.guildMember(guildID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return member.Roles, nil
+}
+
+// hasRole returns true if any of the member roles matches any of the given role IDs or names.
+func (sg *Instance) hasRole(guildID string, memberRoles []string, roles []string) bool {
+	// Role names are only resolved if needed.
+	var guild *discordgo.Guild
+	for _, role := range roles {
+		if containsString(memberRoles, role) {
+			return true
+		}
+		if guild == nil {
+			var err error
+			if guild, err = sg.guild(guildID); err != nil {
+				continue
+			}
+		}
+		// Role may be specified by name.
+		for _, r := range guild.Roles {
+			if strings.EqualFold(r.Name, role) && containsString(memberRoles, r.ID) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkAccess checks if the Request author is allowed to use the command. Guild ACLs of the command and all of it's
+// parents are checked first, users and roles allowed by any of them do not need anything else. Otherwise
+// RolesRequired and PermissionsRequired of the command and all of it's parents are checked. OwnerOnly commands are
+// available to the bot owners only, owners may bypass the rest of the checks depending on OwnerBypass.
+// PermissionError describing the reason is returned if access is denied.
+func (sg *Instance) checkAccess(req *Request, c *Command) error {
+	guildID := req.Channel.GuildID
+	userID := req.Message.Author.ID
+
+	// Owner only commands are not available to anyone else.
+	if c.isOwnerOnly() && !req.IsOwner() {
+		return NewPermissionError("this command is only available to the bot owners")
+	}
+
+	// Owners may be exempt from the checks below.
+	if req.Bypasses(OwnerBypassPermissions) {
+		return nil
+	}
+
+	// Member roles are only requested if needed.
+	var roles []string
+	var rolesLoaded bool
+	getRoles := func() ([]string, error) {
+		if !rolesLoaded && guildID != "" {
+			var err error
+			if roles, err = sg.memberRoles(guildID, userID); err != nil {
+				return nil, err
+			}
+			rolesLoaded = true
+		}
+		return roles, nil
+	}
+
+	// Check the guild ACLs from the command up to the outermost parent.
+	var granted bool
+	if guildID != "" {
+		for cmd := c; cmd != nil; cmd = cmd.parent {
+			acl, err := sg.GetACL(guildID, cmd.GetPath())
+			if err != nil {
+				return err
+			}
+			if acl.Empty() {
+				continue
+			}
+			if containsString(acl.DenyUsers, userID) || containsString(acl.DenyChannels, req.Channel.ID) {
+				return NewPermissionError("you are not allowed to use this command here")
+			}
+			if len(acl.AllowChannels) > 0 && !containsString(acl.AllowChannels, req.Channel.ID) {
+				return NewPermissionError("this command is not allowed in this channel")
+			}
+			if len(acl.DenyRoles) > 0 || len(acl.AllowRoles) > 0 {
+				roles, err := getRoles()
+				if err != nil {
+					return err
+				}
+				if sg.hasRole(guildID, roles, acl.DenyRoles) {
+					return NewPermissionError("your roles are not allowed to use this command")
+				}
+				if sg.hasRole(guildID, roles, acl.AllowRoles) {
+					granted = true
+				}
+			}
+			if containsString(acl.AllowUsers, userID) {
+				granted = true
+			}
+		}
+	}
+
+	// Explicitly allowed users and roles do not need anything else.
+	if granted {
+		return nil
+	}
+
+	// Otherwise user has to meet the requirements of the command and all of it's parents.
+	for cmd := c; cmd != nil; cmd = cmd.parent {
+		// Make sure user has one of the roles required.
+		if len(cmd.RolesRequired) > 0 {
+			if guildID == "" {
+				return NewPermissionError("this command can only be used in guilds")
+			}
+			roles, err := getRoles()
+			if err != nil {
+				return err
+			}
+			if !sg.hasRole(guildID, roles, cmd.RolesRequired) {
+				return NewPermissionError("you need one of the following roles: " + strings.Join(cmd.RolesRequired, ", "))
+			}
+		}
+
+		// Make sure user has permissions necessary to run the command.
+		missing, err := sg.missingUserPermissions(req, cmd.PermissionsRequired)
+		if err != nil {
+			return err
+		}
+		if missing != 0 {
+			return &PermissionError{
+				Message: "you need the following permissions to use this command: **" + FormatPermissions(missing) + "**",
+				Missing: missing,
+			}
+		}
+	}
+
+	return nil
+}
+
+// aclTargets parses the ACL command parameters into the command path and user, role and channel IDs.
+func aclTargets(query string) (path string, users []string, roles []string, channels []string) {
+	var pathParts []string
+	for _, param := range strings.Fields(query) {
+		switch {
+		case strings.HasPrefix(param, "<@&") && strings.HasSuffix(param, ">"):
+			roles = append(roles, strings.TrimSuffix(strings.TrimPrefix(param, "<@&"), ">"))
+		case strings.HasPrefix(param, "<@") && strings.HasSuffix(param, ">"):
+			users = append(users, parseUserID(param))
+		case parseChannelID(param) != "":
+			channels = append(channels, parseChannelID(param))
+		default:
+			pathParts = append(pathParts, param)
+		}
+	}
+	return strings.Join(pathParts, " "), users, roles, channels
+}
+
+// appendUnique appends the items that are not in the slice yet.
+func appendUnique(slice []string, items ...string) []string {
+	for _, item := range items {
+		if !containsString(slice, item) {
+			slice = append(slice, item)
+		}
+	}
+	return slice
+}
+
+// removeStrings removes the items from the slice.
+func removeStrings(slice []string, items ...string) []string {
+	var result []string
+	for _, s := range slice {
+		if !containsString(items, s) {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// formatACL renders the ACL as a human-readable text.
+func formatACL(acl *ACL) string {
+	if acl.Empty() {
+		return "no overrides"
+	}
+	mentions := func(ids []string, prefix string) string {
+		var result []string
+		for _, id := range ids {
+			result = append(result, prefix+id+">")
+		}
+		return strings.Join(result, " ")
+	}
+	var lines []string
+	add := func(title string, ids []string, prefix string) {
+		if len(ids) > 0 {
+			lines = append(lines, "**"+title+":** "+mentions(ids, prefix))
+		}
+	}
+	add("allowed users", acl.AllowUsers, "<@")
+	add("allowed roles", acl.AllowRoles, "<@&")
+	add("allowed channels", acl.AllowChannels, "<#")
+	add("denied users", acl.DenyUsers, "<@")
+	add("denied roles", acl.DenyRoles, "<@&")
+	add("denied channels", acl.DenyChannels, "<#")
+	return strings.Join(lines, "\n")
+}
+
+// ACLCommand makes the command that allows guild administrators to manage access to the commands in their guild. The
+// command is not added automatically, use AddCommand to add it.
+func (sg *Instance) ACLCommand() *Command {
+	// edit applies the change to the ACL of the command given in the Request query.
+	edit := func(req *Request, change func(acl *ACL, users []string, roles []string, channels []string)) (*Response, error) {
+		path, users, roles, channels := aclTargets(req.Query)
+		cmd := sg.GetCommandByPath(path)
+		if cmd == nil {
+			return nil, NewNotFoundError("command " + path)
+		}
+		if len(users)+len(roles)+len(channels) == 0 {
+			return nil, NewUserWarning("please, mention users, roles or channels")
+		}
+
+		acl, err := sg.GetACL(req.Channel.GuildID, cmd.GetPath())
+		if err != nil {
+			return nil, err
+		}
+		change(acl, users, roles, channels)
+		if err = sg.SetACL(req.Channel.GuildID, cmd.GetPath(), acl); err != nil {
+			return nil, err
+		}
+		return req.NewResponse(ResponseSuccess, "acl of "+cmd.GetPath(), formatACL(acl)), nil
+	}
+
+	return &Command{
+		Trigger:             "acl",
+		Description:         "manages access to the commands in this guild",
+		PermissionsRequired: discordgo.PermissionManageServer,
+		RequireGuild:        true,
+		SubCommands: []*Command{
+			{
+				Trigger:     "show",
+				Description: "shows access overrides of the command: acl show <command>",
+				HasParams:   true,
+				Execute: func(req *Request) (*Response, error) {
+					path, _, _, _ := aclTargets(req.Query)
+					cmd := sg.GetCommandByPath(path)
+					if cmd == nil {
+						return nil, NewNotFoundError("command " + path)
+					}
+					acl, err := sg.GetACL(req.Channel.GuildID, cmd.GetPath())
+					if err != nil {
+						return nil, err
+					}
+					return req.NewResponse(ResponseInfo, "acl of "+cmd.GetPath(), formatACL(acl)), nil
+				},
+			},
+			{
+				Trigger:     "allow",
+				Description: "allows users and roles to use the command or restricts it to channels: acl allow <command> <@user|@role|#channel>...",
+				HasParams:   true,
+				Execute: func(req *Request) (*Response, error) {
+					return edit(req, func(acl *ACL, users []string, roles []string, channels []string) {
+						acl.AllowUsers = appendUnique(acl.AllowUsers, users...)
+						acl.AllowRoles = appendUnique(acl.AllowRoles, roles...)
+						acl.AllowChannels = appendUnique(acl.AllowChannels, channels...)
+						acl.DenyUsers = removeStrings(acl.DenyUsers, users...)
+						acl.DenyRoles = removeStrings(acl.DenyRoles, roles...)
+						acl.DenyChannels = removeStrings(acl.DenyChannels, channels...)
+					})
+				},
+			},
+			{
+				Trigger:     "deny",
+				Description: "denies users, roles or channels to use the command: acl deny <command> <@user|@role|#channel>...",
+				HasParams:   true,
+				Execute: func(req *Request) (*Response, error) {
+					return edit(req, func(acl *ACL, users []string, roles []string, channels []string) {
+						acl.DenyUsers = appendUnique(acl.DenyUsers, users...)
+						acl.DenyRoles = appendUnique(acl.DenyRoles, roles...)
+						acl.DenyChannels = appendUnique(acl.DenyChannels, channels...)
+						acl.AllowUsers = removeStrings(acl.AllowUsers, users...)
+						acl.AllowRoles = removeStrings(acl.AllowRoles, roles...)
+						acl.AllowChannels = removeStrings(acl.AllowChannels, channels...)
+					})
+				},
+			},
+			{
+				Trigger:     "remove",
+				Description: "removes users, roles or channels from the command overrides: acl remove <command> <@user|@role|#channel>...",
+				HasParams:   true,
+				Execute: func(req *Request) (*Response, error) {
+					return edit(req, func(acl *ACL, users []string, roles []string, channels []string) {
+						acl.AllowUsers = removeStrings(acl.AllowUsers, users...)
+						acl.AllowRoles = removeStrings(acl.AllowRoles, roles...)
+						acl.AllowChannels = removeStrings(acl.AllowChannels, channels...)
+						acl.DenyUsers = removeStrings(acl.DenyUsers, users...)
+						acl.DenyRoles = removeStrings(acl.DenyRoles, roles...)
+						acl.DenyChannels = removeStrings(acl.DenyChannels, channels...)
+					})
+				},
+			},
+			{
+				Trigger:     "reset",
+				Description: "removes all access overrides of the command: acl reset <command>",
+				HasParams:   true,
+				Execute: func(req *Request) (*Response, error) {
+					path, _, _, _ := aclTargets(req.Query)
+					cmd := sg.GetCommandByPath(path)
+					if cmd == nil {
+						return nil, NewNotFoundError("command " + path)
+					}
+					if err := sg.SetACL(req.Channel.GuildID, cmd.GetPath(), &ACL{}); err != nil {
+						return nil, err
+					}
+					return req.NewResponse(ResponseSuccess, "acl of "+cmd.GetPath(), "all overrides removed"), nil
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,116 @@
+package sugo
+
+import (
+	"context"
+	"github.com/bwmarrin/discordgo"
+	"testing"
+)
+
+func TestCheckAccess(t *testing.T) {
+	tests := []struct {
+		name     string
+		authorID string
+		// acls contains ACLs by command path.
+		acls   map[string]*ACL
+		owners []string
+		want   bool
+	}{
+		{
+			name:     "parent permissions required",
+			authorID: "user",
+			want:     false,
+		},
+		{
+			name:     "guild owner has parent permissions",
+			authorID: "owner",
+			want:     true,
+		},
+		{
+			name:     "allowed user bypasses parent permissions",
+			authorID: "user",
+			acls:     map[string]*ACL{"admin sub": {AllowUsers: []string{"user"}}},
+			want:     true,
+		},
+		{
+			name:     "parent acl allowed role bypasses parent permissions",
+			authorID: "user",
+			acls:     map[string]*ACL{"admin": {AllowRoles: []string{"mods"}}},
+			want:     true,
+		},
+		{
+			name:     "role allowed by name",
+			authorID: "user",
+			acls:     map[string]*ACL{"admin sub": {AllowRoles: []string{"moderators"}}},
+			want:     true,
+		},
+		{
+			name:     "other role allowed",
+			authorID: "user",
+			acls:     map[string]*ACL{"admin sub": {AllowRoles: []string{"other"}}},
+			want:     false,
+		},
+		{
+			name:     "denied user wins over allowed role",
+			authorID: "user",
+			acls: map[string]*ACL{
+				"admin":     {AllowRoles: []string{"mods"}},
+				"admin sub": {DenyUsers: []string{"user"}},
+			},
+			want: false,
+		},
+		{
+			name:     "denied role wins over allowed user",
+			authorID: "user",
+			acls:     map[string]*ACL{"admin sub": {AllowUsers: []string{"user"}, DenyRoles: []string{"mods"}}},
+			want:     false,
+		},
+		{
+			name:     "allowed user outside of allowed channels",
+			authorID: "user",
+			acls:     map[string]*ACL{"admin sub": {AllowUsers: []string{"user"}, AllowChannels: []string{"other"}}},
+			want:     false,
+		},
+		{
+			name:     "bot owner bypasses permissions",
+			authorID: "user",
+			owners:   []string{"user"},
+			want:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sg := newStateInstance(t, testGuild())
+			sg.Owners = tt.owners
+			sg.OwnerBypass = OwnerBypassPermissions
+			execute := func(req *Request) (*Response, error) { return nil, nil }
+			sub := &Command{Trigger: "sub", Execute: execute}
+			sg.AddCommand(&Command{
+				Trigger:             "admin",
+				PermissionsRequired: discordgo.PermissionManageServer,
+				SubCommands:         []*Command{sub},
+			})
+			for path, acl := range tt.acls {
+				if err := sg.SetACL("guild", path, acl); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			req := &Request{
+				ID:      "request",
+				Ctx:     context.Background(),
+				Sugo:    sg,
+				Message: &discordgo.Message{Author: &discordgo.User{ID: tt.authorID}},
+				Channel: &discordgo.Channel{ID: "channel", GuildID: "guild"},
+			}
+			err := sg.checkAccess(req, sub)
+			if tt.want && err != nil {
+				t.Errorf("checkAccess() error = %v, want nil", err)
+			}
+			if !tt.want {
+				if _, ok := err.(*PermissionError); !ok {
+					t.Errorf("checkAccess() error = %v, want permission error", err)
+				}
+			}
+		})
+	}
+}
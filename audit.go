@@ -0,0 +1,343 @@
+package sugo
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditOutcome is the outcome of the command execution.
+type AuditOutcome string
+
+const (
+	// AuditSuccess means command was executed successfully.
+	AuditSuccess AuditOutcome = "success"
+	// AuditError means command execution failed.
+	AuditError AuditOutcome = "error"
+	// AuditDenied means user was not allowed to execute the command.
+	AuditDenied AuditOutcome = "denied"
+	// AuditThrottled means command was not executed due to usage limits.
+	AuditThrottled AuditOutcome = "throttled"
+)
+
+// defaultAuditQueryLimit is the amount of entries returned by the query if no limit is specified.
+const defaultAuditQueryLimit = 20
+
+// AuditEntry is the record of the single command execution.
+type AuditEntry struct {
+	Time      time.Time     `json:"time"`
+	RequestID string        `json:"request_id"`
+	UserID    string        `json:"user_id"`
+	Username  string        `json:"username"`
+	GuildID   string        `json:"guild_id,omitempty"`
+	ChannelID string        `json:"channel_id"`
+	Command   string        `json:"command"`
+	Arguments string        `json:"arguments,omitempty"`
+	Outcome   AuditOutcome  `json:"outcome"`
+	Error     string        `json:"error,omitempty"`
+	Duration  time.Duration `json:"duration"`
+}
+
+// AuditQuery specifies which audit entries to look for. Empty fields match everything.
+type AuditQuery struct {
+	UserID  string
+	GuildID string
+	// Command matches the command with the given path and all of it's subcommands.
+	Command string
+	// Limit is the maximum amount of the most recent entries to return. Defaults to 20.
+	Limit int
+}
+
+// match returns true if entry matches the query.
+func (q AuditQuery) match(entry AuditEntry) bool {
+	if q.UserID != "" && entry.UserID != q.UserID {
+		return false
+	}
+	if q.GuildID != "" && entry.GuildID != q.GuildID {
+		return false
+	}
+	if q.Command != "" && entry.Command != q.Command && !strings.HasPrefix(entry.Command, q.Command+" ") {
+		return false
+	}
+	return true
+}
+
+// limit returns the query limit.
+func (q AuditQuery) limit() int {
+	if q.Limit <= 0 {
+		return defaultAuditQueryLimit
+	}
+	return q.Limit
+}
+
+// AuditSink receives audit entries.
+type AuditSink interface {
+	Write(entry AuditEntry) error
+}
+
+// AuditQuerier is implemented by the audit sinks that can be queried for the recent entries.
+type AuditQuerier interface {
+	// Query returns the most recent entries matching the query, newest first.
+	Query(q AuditQuery) ([]AuditEntry, error)
+}
+
+// MemoryAuditSink keeps the given amount of the most recent audit entries in memory.
+type MemoryAuditSink struct {
+	// mu guards the fields below.
+	mu sync.Mutex
+	// entries is the ring buffer of the entries.
+	entries []AuditEntry
+	// next is the position next entry is written to.
+	next int
+	// full is true if ring buffer was filled at least once.
+	full bool
+}
+
+// NewMemoryAuditSink creates MemoryAuditSink that keeps size most recent entries.
+func NewMemoryAuditSink(size int) *MemoryAuditSink {
+	if size <= 0 {
+		size = 1
+	}
+	return &MemoryAuditSink{entries: make([]AuditEntry, size)}
+}
+
+// Write implements AuditSink interface.
+func (s *MemoryAuditSink) Write(entry AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[s.next] = entry
+	s.next = (s.next + 1) % len(s.entries)
+	if s.next == 0 {
+		s.full = true
+	}
+	return nil
+}
+
+// Query implements AuditQuerier interface.
+func (s *MemoryAuditSink) Query(q AuditQuery) ([]AuditEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := s.next
+	if s.full {
+		count = len(s.entries)
+	}
+
+	// Walk the ring buffer backwards starting from the newest entry.
+	var result []AuditEntry
+	for i := 1; i <= count && len(result) < q.limit(); i++ {
+		entry := s.entries[(s.next-i+len(s.entries))%len(s.entries)]
+		if q.match(entry) {
+			result = append(result, entry)
+		}
+	}
+	return result, nil
+}
+
+// JSONLinesAuditSink appends audit entries to the file as JSON lines.
+type JSONLinesAuditSink struct {
+	// mu guards file.
+	mu sync.Mutex
+	// path is the path to the file.
+	path string
+	// file is the file entries are appended to.
+	file *os.File
+}
+
+// NewJSONLinesAuditSink opens (or creates) the file entries are to be appended to.
+func NewJSONLinesAuditSink(path string) (*JSONLinesAuditSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open audit log file")
+	}
+	return &JSONLinesAuditSink{path: path, file: file}, nil
+}
+
+// Write implements AuditSink interface.
+func (s *JSONLinesAuditSink) Write(entry AuditEntry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Wrap(err, "unable to marshal audit entry")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err = s.file.Write(append(b, '\n')); err != nil {
+		return errors.Wrap(err, "unable to write audit entry")
+	}
+	return nil
+}
+
+// Query implements AuditQuerier interface. The whole file is scanned, so it's not meant to be used too often.
+func (s *JSONLinesAuditSink) Query(q AuditQuery) ([]AuditEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.Open(s.path)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open audit log file")
+	}
+	defer file.Close()
+
+	// Keep only the last matching entries while scanning.
+	var matched []AuditEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// Skip the corrupted lines (e.g. the last one written partially on crash).
+			continue
+		}
+		if q.match(entry) {
+			matched = append(matched, entry)
+			if len(matched) > q.limit() {
+				matched = matched[1:]
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "unable to read audit log file")
+	}
+
+	// Newest first.
+	for i, j := 0, len(matched)-1; i < j; i, j = i+1, j-1 {
+		matched[i], matched[j] = matched[j], matched[i]
+	}
+	return matched, nil
+}
+
+// Close closes the file.
+func (s *JSONLinesAuditSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// auditOutcome determines the outcome of the command execution by the error it returned.
+func auditOutcome(err error) AuditOutcome {
+	if err == nil {
+		return AuditSuccess
+	}
+	switch asResponseError(err).(type) {
+	case *PermissionError, *RestrictionError, *MaintenanceError:
+		return AuditDenied
+	case *ThrottledError:
+		return AuditThrottled
+	}
+	return AuditError
+}
+
+// audit records the Request command execution into the audit sink if it's configured.
+func (sg *Instance) audit(req *Request, duration time.Duration, err error) {
+	if sg.AuditSink == nil || req.Command == nil {
+		return
+	}
+
+	entry := AuditEntry{
+		Time:      time.Now(),
+		RequestID: req.ID,
+		UserID:    req.Message.Author.ID,
+		Username:  req.Message.Author.String(),
+		GuildID:   req.Channel.GuildID,
+		ChannelID: req.Channel.ID,
+		Command:   req.Command.GetPath(),
+		Arguments: req.Query,
+		Outcome:   auditOutcome(err),
+		Duration:  duration,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	if err := sg.AuditSink.Write(entry); err != nil {
+		sg.HandleError(req, errors.Wrap(err, "unable to write audit entry"))
+	}
+}
+
+// AuditCommand makes the command that allows guild administrators to look through the recent audit entries of the
+// guild. AuditSink must implement AuditQuerier for the command to work. The command is not added automatically, use
+// AddCommand to add it.
+func (sg *Instance) AuditCommand() *Command {
+	query := func(req *Request, q AuditQuery) (*Response, error) {
+		querier, ok := sg.AuditSink.(AuditQuerier)
+		if !ok {
+			return nil, NewUserError("audit log is not available")
+		}
+
+		// Guild administrators can only see their own guild entries.
+		q.GuildID = req.Channel.GuildID
+
+		entries, err := querier.Query(q)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to query audit log")
+		}
+		if len(entries) == 0 {
+			return req.NewResponse(ResponseInfo, "audit log", "no entries found"), nil
+		}
+
+		var lines []string
+		for _, entry := range entries {
+			line := "`" + entry.Time.UTC().Format("2006-01-02 15:04:05") + "` <@" + entry.UserID + "> **" +
+				entry.Command + "**"
+			if entry.Arguments != "" {
+				line += " `" + truncate(entry.Arguments, 50) + "`"
+			}
+			line += " in <#" + entry.ChannelID + ">: " + string(entry.Outcome) + " (" +
+				entry.Duration.Round(time.Millisecond).String() + ")"
+			lines = append(lines, line)
+		}
+		return req.NewResponse(ResponseInfo, "audit log", truncate(strings.Join(lines, "\n"), 2000)), nil
+	}
+
+	return &Command{
+		Trigger:             "audit",
+		Description:         "shows recent commands executed in this guild",
+		PermissionsRequired: discordgo.PermissionViewAuditLogs,
+		RequireGuild:        true,
+		Execute: func(req *Request) (*Response, error) {
+			return query(req, AuditQuery{})
+		},
+		SubCommands: []*Command{
+			{
+				Trigger:     "user",
+				Description: "shows recent commands executed by the user: audit user @user",
+				HasParams:   true,
+				Execute: func(req *Request) (*Response, error) {
+					userID := parseUserID(req.Query)
+					if userID == "" {
+						return nil, NewUserWarning("please, specify the user")
+					}
+					return query(req, AuditQuery{UserID: userID})
+				},
+			},
+			{
+				Trigger:     "command",
+				Description: "shows recent executions of the command: audit command <command path>",
+				HasParams:   true,
+				Execute: func(req *Request) (*Response, error) {
+					path := strings.Join(strings.Fields(req.Query), " ")
+					if path == "" {
+						return nil, NewUserWarning("please, specify the command")
+					}
+					return query(req, AuditQuery{Command: path})
+				},
+			},
+		},
+	}
+}
+
+// parseUserID extracts user ID from the user mention or returns the string itself if it's not a mention.
+func parseUserID(s string) string {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "<@") && strings.HasSuffix(s, ">") {
+		s = strings.TrimPrefix(strings.TrimPrefix(strings.TrimSuffix(s, ">"), "<@"), "!")
+	}
+	return s
+}
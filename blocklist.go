@@ -0,0 +1,304 @@
+package sugo
+
+import (
+	"encoding/json"
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+// blocklistNamespace is the storage namespace blocked users and guilds are kept in.
+const blocklistNamespace = "sugo.blocklist"
+
+// BlockKind is the kind of blocked entity.
+type BlockKind string
+
+const (
+	// BlockUser blocks the user from using the bot anywhere.
+	BlockUser BlockKind = "user"
+	// BlockGuild blocks everyone in the guild from using the bot.
+	BlockGuild BlockKind = "guild"
+)
+
+// BlockEntry describes blocked user or guild.
+type BlockEntry struct {
+	Kind      BlockKind `json:"kind"`
+	ID        string    `json:"id"`
+	Reason    string    `json:"reason,omitempty"`
+	BlockedBy string    `json:"blocked_by,omitempty"`
+	BlockedAt time.Time `json:"blocked_at"`
+	// ExpiresAt is the time the block is lifted at. Zero value means never.
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
+// blockKey returns the storage key of the blocked entity.
+func blockKey(kind BlockKind, id string) string {
+	return string(kind) + "s/" + id
+}
+
+// isSnowflake returns true if the string looks like discord ID.
+func isSnowflake(s string) bool {
+	if s == "" || len(s) > 20 {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Block blocks the user or guild for the given duration (forever if zero).
+func (sg *Instance) Block(kind BlockKind, id string, reason string, blockedBy string, duration time.Duration) error {
+	if !isSnowflake(id) {
+		return errors.New("invalid " + string(kind) + " ID: \"" + id + "\"")
+	}
+	entry := &BlockEntry{
+		Kind:      kind,
+		ID:        id,
+		Reason:    reason,
+		BlockedBy: blockedBy,
+		BlockedAt: time.Now().UTC(),
+	}
+	if duration > 0 {
+		entry.ExpiresAt = entry.BlockedAt.Add(duration)
+	}
+	if err := sg.Store(blocklistNamespace).SetJSON(blockKey(kind, id), entry, duration); err != nil {
+		return errors.Wrap(err, "unable to block "+string(kind))
+	}
+	sg.logger().Info(string(kind)+" blocked", "id", id, "reason", reason, "by", blockedBy)
+
+	// Leave the guild right away if asked to.
+	if kind == BlockGuild && sg.AutoLeaveBlockedGuilds && sg.Session != nil {
+		sg.leaveGuild(id)
+	}
+	return nil
+}
+
+// Unblock lifts the block of the user or guild.
+func (sg *Instance) Unblock(kind BlockKind, id string) error {
+	if err := sg.Store(blocklistNamespace).Delete(blockKey(kind, id)); err != nil {
+		return errors.Wrap(err, "unable to unblock "+string(kind))
+	}
+	sg.logger().Info(string(kind)+" unblocked", "id", id)
+	return nil
+}
+
+// GetBlock returns the block of the user or guild or nil if it's not blocked.
+func (sg *Instance) GetBlock(kind BlockKind, id string) (*BlockEntry, error) {
+	if id == "" {
+		return nil, nil
+	}
+	entry := &BlockEntry{}
+	ok, err := sg.Store(blocklistNamespace).GetJSON(blockKey(kind, id), entry)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to check the blocklist")
+	}
+	if !ok {
+		return nil, nil
+	}
+	return entry, nil
+}
+
+// Blocklist returns all the blocked users and guilds, the most recently blocked first.
+func (sg *Instance) Blocklist() ([]*BlockEntry, error) {
+	values, err := sg.Store(blocklistNamespace).List("")
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list the blocklist")
+	}
+	var entries []*BlockEntry
+	for _, value := range values {
+		entry := &BlockEntry{}
+		if err = json.Unmarshal(value, entry); err != nil {
+			return nil, errors.Wrap(err, "unable to decode blocklist entry")
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].BlockedAt.After(entries[j].BlockedAt)
+	})
+	return entries, nil
+}
+
+// isMessageBlocked returns true if the message author or guild is blocked. Guild is given explicitly as the message
+// does not always have it set.
+func (sg *Instance) isMessageBlocked(m *discordgo.Message, guildID string) (bool, error) {
+	for _, check := range []struct {
+		kind BlockKind
+		id   string
+	}{{BlockUser, m.Author.ID}, {BlockGuild, guildID}} {
+		entry, err := sg.GetBlock(check.kind, check.id)
+		if err != nil {
+			return false, err
+		}
+		if entry != nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// leaveGuild makes bot leave the guild.
+func (sg *Instance) leaveGuild(guildID string) {
+	if err := sg.Session.GuildLeave(guildID); err != nil {
+		sg.HandleError(nil, errors.Wrap(err, "unable to leave blocked guild"))
+		return
+	}
+	sg.logger().Info("blocked guild left", "guild", guildID)
+}
+
+// trackBlockedGuilds makes bot leave blocked guilds as soon as it joins them if AutoLeaveBlockedGuilds is set.
+func (sg *Instance) trackBlockedGuilds() {
+	sg.Session.AddHandler(func(_ *discordgo.Session, e *discordgo.GuildCreate) {
+		if !sg.AutoLeaveBlockedGuilds {
+			return
+		}
+		entry, err := sg.GetBlock(BlockGuild, e.ID)
+		if err != nil {
+			sg.HandleError(nil, err)
+			return
+		}
+		if entry != nil {
+			sg.leaveGuild(e.ID)
+		}
+	})
+}
+
+// formatBlockEntry renders the blocklist entry as a single line of text.
+func formatBlockEntry(entry *BlockEntry) string {
+	line := string(entry.Kind) + " `" + entry.ID + "`"
+	if entry.Reason != "" {
+		line += ": " + entry.Reason
+	}
+	if !entry.ExpiresAt.IsZero() {
+		line += " (until " + entry.ExpiresAt.Format(time.RFC3339) + ")"
+	}
+	return line
+}
+
+// parseBlockParams parses "<id> [duration] [reason...]" parameters of the blocklist commands. Users may be given by
+// mention as well. Empty id is returned if it's not valid.
+func parseBlockParams(kind BlockKind, query string) (id string, duration time.Duration, reason string) {
+	params := strings.Fields(query)
+	if len(params) == 0 {
+		return "", 0, ""
+	}
+	id = params[0]
+	if kind == BlockUser {
+		id = parseUserID(id)
+	}
+	if !isSnowflake(id) {
+		return "", 0, ""
+	}
+	params = params[1:]
+	if len(params) > 0 {
+		if d, err := time.ParseDuration(params[0]); err == nil && d > 0 {
+			duration = d
+			params = params[1:]
+		}
+	}
+	return id, duration, strings.Join(params, " ")
+}
+
+// BlocklistCommand makes the owner only command that manages blocked users and guilds. The command is not added
+// automatically, use AddCommand to add it.
+func (sg *Instance) BlocklistCommand() *Command {
+	// block blocks the entity given in the Request query.
+	block := func(req *Request, kind BlockKind) (*Response, error) {
+		id, duration, reason := parseBlockParams(kind, req.Query)
+		if id == "" {
+			return nil, NewUserWarning("usage: blocklist " + string(kind) + " <id> [duration] [reason]")
+		}
+		if kind == BlockUser && sg.IsOwner(id) {
+			return nil, NewUserWarning("bot owners can not be blocked")
+		}
+		if err := sg.Block(kind, id, reason, req.Message.Author.ID, duration); err != nil {
+			return nil, err
+		}
+		entry, err := sg.GetBlock(kind, id)
+		if err != nil || entry == nil {
+			return nil, err
+		}
+		return req.NewResponse(ResponseSuccess, "blocklist", formatBlockEntry(entry)), nil
+	}
+
+	// unblock unblocks the entity given in the Request query.
+	unblock := func(req *Request, kind BlockKind) (*Response, error) {
+		id, _, _ := parseBlockParams(kind, req.Query)
+		if id == "" {
+			return nil, NewUserWarning("usage: blocklist un" + string(kind) + " <id>")
+		}
+		entry, err := sg.GetBlock(kind, id)
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			return nil, NewNotFoundError("blocked " + string(kind) + " " + id)
+		}
+		if err = sg.Unblock(kind, id); err != nil {
+			return nil, err
+		}
+		return req.NewResponse(ResponseSuccess, "blocklist", string(kind)+" `"+id+"` unblocked"), nil
+	}
+
+	return &Command{
+		Trigger:     "blocklist",
+		Description: "manages users and guilds blocked from using the bot",
+		OwnerOnly:   true,
+		SubCommands: []*Command{
+			{
+				Trigger:     "list",
+				Description: "lists all the blocked users and guilds",
+				Execute: func(req *Request) (*Response, error) {
+					entries, err := sg.Blocklist()
+					if err != nil {
+						return nil, err
+					}
+					if len(entries) == 0 {
+						return req.NewResponse(ResponseInfo, "blocklist", "nobody is blocked"), nil
+					}
+					var lines []string
+					for _, entry := range entries {
+						lines = append(lines, formatBlockEntry(entry))
+					}
+					return req.NewResponse(ResponseInfo, "blocklist", truncate(strings.Join(lines, "\n"), 2000)), nil
+				},
+			},
+			{
+				Trigger:     "user",
+				Description: "blocks the user: blocklist user <@user|id> [duration] [reason]",
+				HasParams:   true,
+				Execute: func(req *Request) (*Response, error) {
+					return block(req, BlockUser)
+				},
+			},
+			{
+				Trigger:     "guild",
+				Description: "blocks the guild: blocklist guild <id> [duration] [reason]",
+				HasParams:   true,
+				Execute: func(req *Request) (*Response, error) {
+					return block(req, BlockGuild)
+				},
+			},
+			{
+				Trigger:     "unuser",
+				Description: "unblocks the user: blocklist unuser <@user|id>",
+				HasParams:   true,
+				Execute: func(req *Request) (*Response, error) {
+					return unblock(req, BlockUser)
+				},
+			},
+			{
+				Trigger:     "unguild",
+				Description: "unblocks the guild: blocklist unguild <id>",
+				HasParams:   true,
+				Execute: func(req *Request) (*Response, error) {
+					return unblock(req, BlockGuild)
+				},
+			},
+		},
+	}
+}
@@ -4,26 +4,72 @@ import (
 	"github.com/bwmarrin/discordgo"
 	"github.com/pkg/errors"
 	"strings"
+	"sync/atomic"
 )
 
 // Command struct describes basic command type.
 type Command struct {
 	// Trigger is a sequence of symbols message should start with to match with the command.
 	Trigger string
+	// Module is the name of the module command belongs to. Subcommands belong to the module of their parent unless
+	// they have their own. Guild administrators can disable whole modules at once.
+	Module string
 	// Description should contain short command description.
 	Description string
 	// HasParams specifies if command can have additional parameters in Request string.
 	HasParams bool
 	// PermissionsRequired specifies permissions set required by the command.
 	PermissionsRequired int
-	// RequireGuild specifies if this command works in guild chats only.
+	// BotPermissionsRequired specifies permissions set bot must have in the channel to execute the command.
+	BotPermissionsRequired int
+	// RolesRequired contains role IDs or names, user must have at least one of them to use the command.
+	RolesRequired []string
+	// OwnerOnly restricts the command (and all of it's subcommands) to the bot owners.
+	OwnerOnly bool
+	// Policies decide whether the command (and all of it's subcommands) can be executed after permissions checks,
+	// e.g. QuotaPolicy or SchedulePolicy.
+	Policies []Policy
+	// RequireGuild specifies if this command works in guild text and news channels only.
 	RequireGuild bool
+	// DMOnly specifies if this command works in direct messages only.
+	DMOnly bool
+	// AllowedChannelTypes restricts the command to the channels of the given types if not empty.
+	AllowedChannelTypes []discordgo.ChannelType
+	// NSFWOnly specifies if this command works in NSFW channels only.
+	NSFWOnly bool
+	// AllowedChannels restricts the command to the given channel IDs if not empty.
+	AllowedChannels []string
+	// BlockedChannels contains IDs of the channels command does not work in.
+	BlockedChannels []string
+	// RestrictionMessages override the messages user gets when the channel restrictions above are violated.
+	RestrictionMessages map[Restriction]string
 	// Execute method is executed if Request string matches the given command.
 	Execute func(req *Request) (*Response, error)
 	// SubCommands contains all subcommands of the given command.
 	SubCommands []*Command
 	// parentCommand contains command, which is parent for this one.
 	parent *Command
+	// middlewares wrap execution of the command and all of it's subcommands.
+	middlewares middlewareChain
+	// localMiddlewares wrap execution of the command itself only.
+	localMiddlewares middlewareChain
+	// disabled is set to 1 if command is disabled.
+	disabled int32
+}
+
+// Disable disables the command along with all its subcommands. Disabled commands are never matched.
+func (c *Command) Disable() {
+	atomic.StoreInt32(&c.disabled, 1)
+}
+
+// Enable enables previously disabled command.
+func (c *Command) Enable() {
+	atomic.StoreInt32(&c.disabled, 0)
+}
+
+// IsDisabled returns true if command is disabled and false otherwise.
+func (c *Command) IsDisabled() bool {
+	return atomic.LoadInt32(&c.disabled) == 1
 }
 
 // GetSubcommandsTriggers return all subcommands triggers of the given command available for given user.
@@ -32,8 +78,15 @@ func (c *Command) GetSubcommandsTriggers(sg *Instance, req *Request) []string {
 
 	// For every subcommand:
 	for _, subCommand := range c.SubCommands {
-		// If user has permissions to use the command:
-		if sg.hasPermissions(req, subCommand.PermissionsRequired) {
+		// Skip commands that are disabled or do not work in the Request channel.
+		if _, violated := subCommand.violatedRestriction(req); violated || subCommand.IsDisabled() {
+			continue
+		}
+		if disabled, err := sg.isDisabledInChannel(req.Channel, subCommand); err != nil || disabled {
+			continue
+		}
+		// If user is allowed to use the command:
+		if sg.checkAccess(req, subCommand) == nil {
 			// Add subcommand trigger to the list.
 			triggers = append(triggers, subCommand.Trigger)
 		}
@@ -50,45 +103,117 @@ func (c *Command) GetPath() (value string) {
 	return c.Trigger
 }
 
-// match is a system matching function that checks if command Trigger matches the start of message content.
-func (c *Command) match(sg *Instance, req *Request, q string) bool {
-	// If command is for guild Text channels only and executed elsewhere - it's not a match.
-	if c.RequireGuild && req.Channel.Type != discordgo.ChannelTypeGuildText {
-		return false
+// commandDenial is returned from the command search when the command user asked for can not be used. It carries the
+// command attempted along with the reason.
+type commandDenial struct {
+	// Command is the command attempted.
+	Command *Command
+	// Reason is the reason command can not be used.
+	Reason ResponseError
+	// Explainable is true if it's fine to show the Reason to the user.
+	Explainable bool
+}
+
+// Error implements error interface.
+func (d *commandDenial) Error() string {
+	return d.Reason.Error()
+}
+
+// Cause returns the reason command can not be used.
+func (d *commandDenial) Cause() error {
+	return d.Reason
+}
+
+// deny makes commandDenial for the command. Only the whole word Trigger matches are worth a denial, owner only
+// commands are never explained to anyone else.
+func (c *Command) deny(req *Request, q string, reason ResponseError) *commandDenial {
+	if q != c.Trigger && !strings.HasPrefix(q, c.Trigger+" ") {
+		return nil
+	}
+	return &commandDenial{
+		Command:     c,
+		Reason:      reason,
+		Explainable: !c.isOwnerOnly() || req.IsOwner(),
+	}
+}
+
+// match is a system matching function that checks if command Trigger matches the start of message content. If the
+// Trigger matches, but command can not be used here - the denial is returned along with false. Access is checked by
+// authorize once the command is picked, so ACLs of the subcommands are taken into account.
+func (c *Command) match(sg *Instance, req *Request, q string) (bool, *commandDenial) {
+	// Disabled commands never match.
+	if c.IsDisabled() {
+		return false, nil
 	}
 
 	// If command is empty and trigger not set - we consider this a match.
 	if c.Trigger == "" && q == "" {
-		return true
+		if _, violated := c.violatedRestriction(req); violated {
+			return false, nil
+		}
+		return true, nil
 	}
 
 	// If trigger is set and in the query:
 	if c.Trigger != "" && strings.HasPrefix(q, c.Trigger) {
-		// Make sure user has permissions necessary to run the command.
-		return sg.hasPermissions(req, c.PermissionsRequired)
+		// If command can not be used in the Request channel - it's not a match.
+		if restriction, violated := c.violatedRestriction(req); violated {
+			return false, c.deny(req, q, NewRestrictionError(restriction, sg.restrictionMessage(c, restriction)))
+		}
+		return true, nil
 	}
 
 	// If no trigger is set and query is not empty then it's not a match.
-	return false
+	return false, nil
 }
 
-// search searches for matching command (including permissions checks) in the given command's subcommands.
+// authorize makes sure user is allowed to run the matched command. Denial is returned otherwise, q is the query
+// the command was matched against.
+func (c *Command) authorize(sg *Instance, req *Request, q string) (bool, *commandDenial) {
+	// Commands without trigger are not checked.
+	if c.Trigger == "" {
+		return true, nil
+	}
+
+	if err := sg.checkAccess(req, c); err != nil {
+		sg.metrics().PermissionDenials.Inc(c.GetPath())
+		permErr, ok := err.(*PermissionError)
+		if !ok {
+			sg.HandleError(req, errors.Wrap(err, "unable to check access"))
+			return false, nil
+		}
+		return false, c.deny(req, q, permErr)
+	}
+	return true, nil
+}
+
+// search searches for matching command (including permissions checks) in the given command's subcommands. If there
+// is no match, but some command can not be used in the Request channel or by the user - the denial is returned as an
+// error. Subcommands are searched before the access to the command itself is checked, so the subcommand may be
+// allowed by the ACL even if it's parent is not.
 func (c *Command) search(sg *Instance, req *Request, q string) (*Command, error) {
+	var denial *commandDenial
+
 	// For every command in subcommands list. We start iterating immediately without considering top level command,
 	// because our top level command on bot is an artificial one to contain real ones. So this top level command is
 	// simply ignored.
 	for _, cmd := range c.SubCommands {
 		// If message does not match command:
-		if !cmd.match(sg, req, q) {
+		matched, cmdDenial := cmd.match(sg, req, q)
+		if !matched {
+			// Remember the denial in case nothing else matches.
+			if cmdDenial != nil && denial == nil {
+				denial = cmdDenial
+			}
 			// Continue searching.
 			continue
 		}
 
 		// Make sure to strip away the Trigger of the parent command we have already found as matching.
-		q = strings.TrimSpace(strings.TrimPrefix(q, cmd.Trigger))
+		rest := strings.TrimSpace(strings.TrimPrefix(q, cmd.Trigger))
 
 		// Try to find subcommand that matches the remainder of the query.
-		subCmd, err := cmd.search(sg, req, q)
+		subCmd, err := cmd.search(sg, req, rest)
 		if err != nil {
 			return nil, err
 		}
@@ -98,24 +223,38 @@ func (c *Command) search(sg *Instance, req *Request, q string) (*Command, error)
 		}
 
 		// Otherwise return our parent command whose subcommands we were iterating over.
-		// Either q should be empty (fully consumed by matching) or the command we are going to return should be able to
-		// accept and process parameters.
+		// Either rest should be empty (fully consumed by matching) or the command we are going to return should be
+		// able to accept and process parameters.
 		// It's done to exclude false positives that tend to happen when you try to use subcommands and spell them
 		// improperly, which results in a situation where we return parent command with it's improperly spelled
 		// subcommand Trigger as a parameter.
-		if q == "" || cmd.HasParams {
-			return cmd, nil
+		if rest == "" || cmd.HasParams {
+			allowed, cmdDenial := cmd.authorize(sg, req, q)
+			if allowed {
+				return cmd, nil
+			}
+			if cmdDenial != nil && denial == nil {
+				denial = cmdDenial
+			}
 		}
 
 		// Otherwise continue with searching another command.
 	}
 
-	// No subcommands matched.
+	// No subcommands matched, tell why if some command was denied.
+	if denial != nil {
+		return nil, denial
+	}
 	return nil, nil
 }
 
 // validate validates commands for them to have either Execute method defined or have subcommands.
 func (c *Command) validate() error {
+	// Make sure policies are configured properly.
+	if err := c.validatePolicies(); err != nil {
+		return err
+	}
+
 	// If command has Execute function defined - we consider it valid and subcommands do not matter.
 	if c.Execute != nil {
 		return nil
@@ -153,7 +292,5 @@ func (c *Command) execute(sg *Instance, req *Request) (resp *Response, err error
 	}
 
 	// Otherwise there must be subcommands. Notify user that command is used incorrectly.
-	resp = req.NewResponse(ResponseDanger, "", "I'm unable to execute this command itself, try subcommands instead")
-
-	return
+	return nil, NewUserError("I'm unable to execute this command itself, try subcommands instead")
 }
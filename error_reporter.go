@@ -0,0 +1,266 @@
+package sugo
+
+import (
+	"context"
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxReportLength is the maximum length of the error details in the report. Discord limits embed description length
+// to 2048 symbols.
+const maxReportLength = 1800
+
+// numbersRe matches numbers in the error messages: IDs, counts, durations etc. differ between identical errors.
+var numbersRe = regexp.MustCompile(`[0-9]+`)
+
+// ErrorReporter posts internal errors into the discord channel. Identical errors are grouped: only the first one
+// within the Window is posted right away, others are counted and posted as a part of the periodic digest. Errors are
+// posted in background, so reporting never slows the Request processing down.
+type ErrorReporter struct {
+	// ChannelID is the ID of the channel reports are posted to.
+	ChannelID string
+	// Window is the time span identical errors are grouped within. Defaults to 10 minutes.
+	Window time.Duration
+	// DigestInterval specifies how often the digest of the grouped errors is posted. Defaults to Window.
+	DigestInterval time.Duration
+	// QueueSize is the maximum amount of reports waiting to be posted, reports that do not fit are dropped (but still
+	// counted in the digest). Defaults to 100.
+	QueueSize int
+
+	// mu guards groups and queue.
+	mu sync.Mutex
+	// groups contains the groups of identical errors by fingerprint.
+	groups map[string]*errorGroup
+	// queue contains the reports waiting to be posted.
+	queue chan *errorReport
+}
+
+// errorReport is the error waiting to be posted.
+type errorReport struct {
+	req *Request
+	err error
+}
+
+// errorGroup contains the occurrences of identical errors.
+type errorGroup struct {
+	// path is the path of the command error happened in.
+	path string
+	// message is the error message.
+	message string
+	// first is the time of the first occurrence of the error.
+	first time.Time
+	// count is the amount of the error occurrences.
+	count int
+	// reported is the amount of the error occurrences that were already reported.
+	reported int
+}
+
+// window returns the grouping window.
+func (r *ErrorReporter) window() time.Duration {
+	if r.Window <= 0 {
+		return 10 * time.Minute
+	}
+	return r.Window
+}
+
+// digestInterval returns the digest interval.
+func (r *ErrorReporter) digestInterval() time.Duration {
+	if r.DigestInterval <= 0 {
+		return r.window()
+	}
+	return r.DigestInterval
+}
+
+// queueSize returns the maximum amount of the queued reports.
+func (r *ErrorReporter) queueSize() int {
+	if r.QueueSize <= 0 {
+		return 100
+	}
+	return r.QueueSize
+}
+
+// reports returns the queue of the reports waiting to be posted.
+func (r *ErrorReporter) reports() chan *errorReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.queue == nil {
+		r.queue = make(chan *errorReport, r.queueSize())
+	}
+	return r.queue
+}
+
+// fingerprint identifies identical errors: errors of the same type and message (numbers aside) that happened in the
+// same command. Wrapping messages are not taken into account, as they differ depending on where error was handled.
+func fingerprint(path string, err error) string {
+	cause := errors.Cause(err)
+	return path + "\x00" + fmt.Sprintf("%T", cause) + "\x00" + numbersRe.ReplaceAllString(cause.Error(), "#")
+}
+
+// report queues the error to be posted into the reports channel unless identical error was already posted within the
+// window. It never blocks: if the queue is full the report is dropped and the error is only counted in the digest.
+func (r *ErrorReporter) report(sg *Instance, req *Request, err error) {
+	// Error is only considered identical if it happened in the same command.
+	var path string
+	if req != nil && req.Command != nil {
+		path = req.Command.GetPath()
+	}
+	key := fingerprint(path, err)
+
+	// Register the error occurrence.
+	r.mu.Lock()
+	if r.groups == nil {
+		r.groups = map[string]*errorGroup{}
+	}
+	group, ok := r.groups[key]
+	if !ok {
+		group = &errorGroup{path: path, message: err.Error(), first: time.Now(), reported: 1}
+		r.groups[key] = group
+	}
+	group.count++
+	r.mu.Unlock()
+
+	// If it's not the first occurrence - it will be reported in the digest.
+	if ok {
+		return
+	}
+
+	select {
+	case r.reports() <- &errorReport{req: req, err: err}:
+	default:
+		// Let the digest tell about it.
+		r.mu.Lock()
+		group.reported--
+		r.mu.Unlock()
+		sg.logger().Warn("error report dropped: queue is full", "error", err)
+	}
+}
+
+// flush posts all the queued reports.
+func (r *ErrorReporter) flush(sg *Instance) {
+	for {
+		select {
+		case report := <-r.reports():
+			r.send(sg, r.makeReport(sg, report.req, report.err))
+		default:
+			return
+		}
+	}
+}
+
+// makeReport makes the embed that describes the error in details.
+func (r *ErrorReporter) makeReport(sg *Instance, req *Request, err error) *discordgo.MessageEmbed {
+	embed := &discordgo.MessageEmbed{
+		Title:       ":exclamation: internal error",
+		Description: "```\n" + truncate(fmt.Sprintf("%+v", err), maxReportLength) + "\n```",
+		Color:       ColorDanger,
+		Timestamp:   time.Now().Format(time.RFC3339),
+	}
+
+	// If error happened outside of the Request - there is nothing else we can tell about it.
+	if req == nil {
+		return embed
+	}
+
+	embed.Footer = &discordgo.MessageEmbedFooter{Text: "error id: " + req.ID}
+	if req.Command != nil {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: "Command", Value: req.Command.GetPath(), Inline: true})
+	}
+	if req.Channel != nil {
+		if req.Channel.GuildID != "" {
+			guild := req.Channel.GuildID
+			if g, err := req.GetGuild(); err == nil {
+				guild = g.Name + " (" + g.ID + ")"
+			}
+			embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: "Guild", Value: guild, Inline: true})
+		}
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: "Channel", Value: req.Channel.Mention(), Inline: true})
+	}
+	if req.Message != nil {
+		embed.Fields = append(embed.Fields,
+			&discordgo.MessageEmbedField{Name: "User", Value: req.Message.Author.Mention() + " (" + req.Message.Author.String() + ")", Inline: true},
+			&discordgo.MessageEmbedField{Name: "Query", Value: "`" + truncate(req.Message.Content, 1000) + "`"},
+		)
+	}
+
+	return embed
+}
+
+// digest posts the counts of the error occurrences that were not reported yet and forgets the groups that are older
+// than the window.
+func (r *ErrorReporter) digest(sg *Instance) {
+	var lines []string
+
+	r.mu.Lock()
+	for fingerprint, group := range r.groups {
+		if group.count > group.reported {
+			line := strconv.Itoa(group.count-group.reported) + "× `" + truncate(group.message, 200) + "`"
+			if group.path != "" {
+				line += " in **" + group.path + "**"
+			}
+			lines = append(lines, line)
+			group.reported = group.count
+		}
+		if time.Since(group.first) >= r.window() {
+			delete(r.groups, fingerprint)
+		}
+	}
+	r.mu.Unlock()
+
+	// Nothing to report.
+	if len(lines) == 0 {
+		return
+	}
+
+	sort.Strings(lines)
+	r.send(sg, &discordgo.MessageEmbed{
+		Title:       ":warning: repeated errors digest",
+		Description: truncate(strings.Join(lines, "\n"), maxReportLength),
+		Color:       ColorWarning,
+		Timestamp:   time.Now().Format(time.RFC3339),
+	})
+}
+
+// run posts queued reports and periodic digests until context is cancelled.
+func (r *ErrorReporter) run(ctx context.Context, sg *Instance) {
+	ticker := time.NewTicker(r.digestInterval())
+	defer ticker.Stop()
+	reports := r.reports()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case report := <-reports:
+			r.send(sg, r.makeReport(sg, report.req, report.err))
+		case <-ticker.C:
+			r.digest(sg)
+		}
+	}
+}
+
+// send posts the report embed into the reports channel. Errors are only logged, since reporting them would most
+// likely fail the same way.
+func (r *ErrorReporter) send(sg *Instance, embed *discordgo.MessageEmbed) {
+	if sg.Session == nil {
+		return
+	}
+	if _, err := sg.Session.ChannelMessageSendEmbed(r.ChannelID, embed); err != nil {
+		sg.logger().Error("unable to post error report", "error", err, "channel", r.ChannelID)
+	}
+}
+
+// truncate truncates the string to the given length (in runes).
+func truncate(s string, length int) string {
+	runes := []rune(s)
+	if len(runes) <= length {
+		return s
+	}
+	return string(runes[:length-1]) + "…"
+}
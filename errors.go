@@ -0,0 +1,352 @@
+package sugo
+
+import (
+	"github.com/bwmarrin/discordgo"
+	"time"
+)
+
+// ResponseError is an error that can be shown to the user as a Response.
+type ResponseError interface {
+	error
+	// GetCorrelationID returns the ID user can quote to identify the error occurrence.
+	GetCorrelationID() string
+	// GetResponseType returns the type of the Response error is to be rendered as.
+	GetResponseType() responseType
+	// GetUserMessage returns the message that is safe to be shown to the user.
+	GetUserMessage() string
+	// setCorrelationID sets the correlation ID of the error.
+	setCorrelationID(id string)
+}
+
+// ErrorRenderer makes a Response out of the error to be sent to the user.
+type ErrorRenderer func(req *Request, err ResponseError) *Response
+
+// correlation contains the correlation ID of the error.
+type correlation struct {
+	// CorrelationID is the ID user can quote to identify the error occurrence. It's the ID of the Request error
+	// happened in.
+	CorrelationID string
+}
+
+// GetCorrelationID returns the ID user can quote to identify the error occurrence.
+func (c *correlation) GetCorrelationID() string {
+	return c.CorrelationID
+}
+
+// setCorrelationID sets the correlation ID of the error.
+func (c *correlation) setCorrelationID(id string) {
+	c.CorrelationID = id
+}
+
+// UserError is an error caused by the user (wrong command usage, invalid parameters etc.). It's message is shown to
+// the user as is.
+type UserError struct {
+	correlation
+	// Message is shown to the user.
+	Message string
+	// Type is the type of the Response error is rendered as, ResponseDanger by default.
+	Type responseType
+}
+
+// NewUserError creates UserError that is shown to the user as a Danger Response.
+func NewUserError(message string) *UserError {
+	return &UserError{Message: message, Type: ResponseDanger}
+}
+
+// NewUserWarning creates UserError that is shown to the user as a Warning Response.
+func NewUserWarning(message string) *UserError {
+	return &UserError{Message: message, Type: ResponseWarning}
+}
+
+// Error implements error interface.
+func (e *UserError) Error() string {
+	return e.Message
+}
+
+// GetResponseType returns the type of the Response error is to be rendered as.
+func (e *UserError) GetResponseType() responseType {
+	if e.Type == "" {
+		return ResponseDanger
+	}
+	return e.Type
+}
+
+// GetUserMessage returns the message that is safe to be shown to the user.
+func (e *UserError) GetUserMessage() string {
+	return e.Message
+}
+
+// NotFoundError is an error that is returned when something user asked for does not exist.
+type NotFoundError struct {
+	correlation
+	// What contains description of the thing that was not found.
+	What string
+}
+
+// NewNotFoundError creates NotFoundError for the thing described.
+func NewNotFoundError(what string) *NotFoundError {
+	return &NotFoundError{What: what}
+}
+
+// Error implements error interface.
+func (e *NotFoundError) Error() string {
+	return e.What + " not found"
+}
+
+// GetResponseType returns the type of the Response error is to be rendered as.
+func (e *NotFoundError) GetResponseType() responseType {
+	return ResponseWarning
+}
+
+// GetUserMessage returns the message that is safe to be shown to the user.
+func (e *NotFoundError) GetUserMessage() string {
+	return e.Error()
+}
+
+// PermissionError is an error that is returned when user is not allowed to do what they asked for.
+type PermissionError struct {
+	correlation
+	// Message is shown to the user.
+	Message string
+	// Missing contains the permissions user is missing if that's the reason of the error.
+	Missing int
+}
+
+// NewPermissionError creates PermissionError with the given message.
+func NewPermissionError(message string) *PermissionError {
+	return &PermissionError{Message: message}
+}
+
+// Error implements error interface.
+func (e *PermissionError) Error() string {
+	return e.Message
+}
+
+// GetResponseType returns the type of the Response error is to be rendered as.
+func (e *PermissionError) GetResponseType() responseType {
+	return ResponseDanger
+}
+
+// GetUserMessage returns the message that is safe to be shown to the user.
+func (e *PermissionError) GetUserMessage() string {
+	return e.Message
+}
+
+// BotPermissionError is an error that is returned when bot lacks the permissions necessary to execute the command.
+type BotPermissionError struct {
+	correlation
+	// Missing contains the permissions bot is missing.
+	Missing int
+}
+
+// NewBotPermissionError creates BotPermissionError for the missing permissions. Zero missing means it's not known
+// which permissions are missing.
+func NewBotPermissionError(missing int) *BotPermissionError {
+	return &BotPermissionError{Missing: missing}
+}
+
+// Error implements error interface.
+func (e *BotPermissionError) Error() string {
+	if e.Missing == 0 {
+		return "bot is missing permissions"
+	}
+	return "bot is missing permissions: " + FormatPermissions(e.Missing)
+}
+
+// GetResponseType returns the type of the Response error is to be rendered as.
+func (e *BotPermissionError) GetResponseType() responseType {
+	return ResponseDanger
+}
+
+// GetUserMessage returns the message that is safe to be shown to the user.
+func (e *BotPermissionError) GetUserMessage() string {
+	if e.Missing == 0 {
+		return "I'm missing necessary permissions to do that, contact server admin or responsible person to fix this"
+	}
+	return "I need the following permissions in this channel to do that: **" + FormatPermissions(e.Missing) +
+		"**, contact server admin or responsible person to fix this"
+}
+
+// RestrictionError is an error that is returned when command is used in the channel it does not work in.
+type RestrictionError struct {
+	correlation
+	// Restriction is the restriction violated.
+	Restriction Restriction
+	// Message is shown to the user.
+	Message string
+}
+
+// NewRestrictionError creates RestrictionError for the violated restriction with the given message.
+func NewRestrictionError(restriction Restriction, message string) *RestrictionError {
+	return &RestrictionError{Restriction: restriction, Message: message}
+}
+
+// Error implements error interface.
+func (e *RestrictionError) Error() string {
+	return e.Message
+}
+
+// GetResponseType returns the type of the Response error is to be rendered as.
+func (e *RestrictionError) GetResponseType() responseType {
+	return ResponseWarning
+}
+
+// GetUserMessage returns the message that is safe to be shown to the user.
+func (e *RestrictionError) GetUserMessage() string {
+	return e.Message
+}
+
+// MaintenanceError is an error that is returned when the bot is under maintenance.
+type MaintenanceError struct {
+	correlation
+	// Message is shown to the user.
+	Message string
+	// ETA is the time maintenance is expected to end at. Zero value means unknown.
+	ETA time.Time
+}
+
+// Error implements error interface.
+func (e *MaintenanceError) Error() string {
+	return e.Message
+}
+
+// GetResponseType returns the type of the Response error is to be rendered as.
+func (e *MaintenanceError) GetResponseType() responseType {
+	return ResponseWarning
+}
+
+// GetUserMessage returns the message that is safe to be shown to the user.
+func (e *MaintenanceError) GetUserMessage() string {
+	return e.Message
+}
+
+// ThrottledError is an error that is returned when user hits the usage limits.
+type ThrottledError struct {
+	correlation
+	// Message is shown to the user.
+	Message string
+	// RetryAt is the time command becomes available again. Zero value means it's unknown.
+	RetryAt time.Time
+}
+
+// NewThrottledError creates ThrottledError with the given message and the time command becomes available again.
+func NewThrottledError(message string, retryAt time.Time) *ThrottledError {
+	return &ThrottledError{Message: message, RetryAt: retryAt}
+}
+
+// Error implements error interface.
+func (e *ThrottledError) Error() string {
+	return e.Message
+}
+
+// GetResponseType returns the type of the Response error is to be rendered as.
+func (e *ThrottledError) GetResponseType() responseType {
+	return ResponseWarning
+}
+
+// GetUserMessage returns the message that is safe to be shown to the user.
+func (e *ThrottledError) GetUserMessage() string {
+	if e.RetryAt.IsZero() {
+		return e.Message
+	}
+	return e.Message + ", try again in " + time.Until(e.RetryAt).Round(time.Second).String()
+}
+
+// InternalError is an unexpected error. It's details are never shown to the user, user gets generic message instead.
+// All the errors that are not ResponseError are considered internal.
+type InternalError struct {
+	correlation
+	// Err is the actual error.
+	Err error
+}
+
+// Error implements error interface.
+func (e *InternalError) Error() string {
+	return e.Err.Error()
+}
+
+// Cause returns the actual error.
+func (e *InternalError) Cause() error {
+	return e.Err
+}
+
+// GetResponseType returns the type of the Response error is to be rendered as.
+func (e *InternalError) GetResponseType() responseType {
+	return ResponseDanger
+}
+
+// GetUserMessage returns the message that is safe to be shown to the user.
+func (e *InternalError) GetUserMessage() string {
+	return "something went wrong, please try again later"
+}
+
+// errorType returns the type of the error to be used as a metric label.
+func errorType(err ResponseError) string {
+	switch err.(type) {
+	case *UserError:
+		return "user"
+	case *NotFoundError:
+		return "not_found"
+	case *PermissionError:
+		return "permission"
+	case *BotPermissionError:
+		return "bot_permission"
+	case *RestrictionError:
+		return "restriction"
+	case *MaintenanceError:
+		return "maintenance"
+	case *ThrottledError:
+		return "throttled"
+	}
+	return "internal"
+}
+
+// asResponseError searches the chain of wrapped errors for the ResponseError. Discord permission errors are converted
+// to BotPermissionError. If there is none - the error is wrapped
+// into InternalError.
+func asResponseError(err error) ResponseError {
+	for e := err; e != nil; {
+		if respErr, ok := e.(ResponseError); ok {
+			return respErr
+		}
+		if restErr, ok := e.(*discordgo.RESTError); ok && restErr.Message != nil &&
+			restErr.Message.Code == discordgo.ErrCodeMissingPermissions {
+			return NewBotPermissionError(0)
+		}
+		causer, ok := e.(interface{ Cause() error })
+		if !ok {
+			break
+		}
+		e = causer.Cause()
+	}
+	return &InternalError{Err: err}
+}
+
+// renderError makes a Response for the error that happened while processing the Request. Returns the ResponseError
+// the error was converted to along with the Response.
+func (sg *Instance) renderError(req *Request, err error) (ResponseError, *Response) {
+	respErr := asResponseError(err)
+
+	// Tie the error to the Request.
+	if respErr.GetCorrelationID() == "" {
+		respErr.setCorrelationID(req.ID)
+	}
+
+	// Use custom renderer if any.
+	if renderer, ok := sg.ErrorRenderers[respErr.GetResponseType()]; ok {
+		return respErr, renderer(req, respErr)
+	}
+
+	return respErr, defaultErrorRenderer(req, respErr)
+}
+
+// defaultErrorRenderer renders error as a Response of the error type with the correlation ID in the footer.
+func defaultErrorRenderer(req *Request, err ResponseError) *Response {
+	resp := req.NewResponse(err.GetResponseType(), "", err.GetUserMessage())
+	if resp.Embed != nil {
+		resp.Embed.Footer = &discordgo.MessageEmbedFooter{Text: "error id: " + err.GetCorrelationID()}
+	} else {
+		resp.Text += "\n`error id: " + err.GetCorrelationID() + "`"
+	}
+	return resp
+}
@@ -0,0 +1,297 @@
+package sugo
+
+import (
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+	"sort"
+	"strings"
+)
+
+// guildCommandsNamespace is the storage namespace commands and modules disabled in guilds are kept in.
+const guildCommandsNamespace = "sugo.commands"
+
+// guildDisabled describes where the command or module is disabled in the guild.
+type guildDisabled struct {
+	// Channels contains IDs of the channels command or module is disabled in. Empty list means the whole guild.
+	Channels []string `json:"channels,omitempty"`
+}
+
+// covers returns true if the channel is one of those disabled.
+func (d *guildDisabled) covers(channelID string) bool {
+	return len(d.Channels) == 0 || containsString(d.Channels, channelID)
+}
+
+// GetModule returns the module of the command, which is the Module of the command itself or of the closest parent
+// that has it.
+func (c *Command) GetModule() string {
+	for cmd := c; cmd != nil; cmd = cmd.parent {
+		if cmd.Module != "" {
+			return cmd.Module
+		}
+	}
+	return ""
+}
+
+// isAncestorOf returns true if the command is the other one or any of it's parents.
+func (c *Command) isAncestorOf(other *Command) bool {
+	for cmd := other; cmd != nil; cmd = cmd.parent {
+		if cmd == c {
+			return true
+		}
+	}
+	return false
+}
+
+// Modules returns the names of all the modules of the registered commands.
+func (sg *Instance) Modules() []string {
+	var modules []string
+	for _, cmd := range sg.Commands() {
+		if cmd.Module != "" {
+			modules = appendUnique(modules, cmd.Module)
+		}
+	}
+	sort.Strings(modules)
+	return modules
+}
+
+// guildCommandKey returns the storage key for the command path.
+func guildCommandKey(path string) string {
+	return "command/" + path
+}
+
+// guildModuleKey returns the storage key for the module.
+func guildModuleKey(module string) string {
+	return "module/" + module
+}
+
+// guildCommandsStore returns the store of the commands and modules disabled in the guild.
+func (sg *Instance) guildCommandsStore(guildID string) *Store {
+	store := sg.Store(guildCommandsNamespace)
+	store.prefix = guildStorePrefix(guildID)
+	return store
+}
+
+// disableInGuild disables the command or module under the key in the guild channels or in the whole guild if no
+// channels are given.
+func (sg *Instance) disableInGuild(guildID string, key string, channels []string) error {
+	store := sg.guildCommandsStore(guildID)
+	var disabled guildDisabled
+	ok, err := store.GetJSON(key, &disabled)
+	if err != nil {
+		return err
+	}
+	if len(channels) == 0 {
+		disabled.Channels = nil
+	} else if !ok || len(disabled.Channels) > 0 {
+		disabled.Channels = appendUnique(disabled.Channels, channels...)
+	}
+	return store.SetJSON(key, &disabled, 0)
+}
+
+// enableInGuild enables the command or module under the key in the guild channels or in the whole guild if no
+// channels are given.
+func (sg *Instance) enableInGuild(guildID string, key string, channels []string) error {
+	store := sg.guildCommandsStore(guildID)
+	if len(channels) == 0 {
+		return store.Delete(key)
+	}
+	var disabled guildDisabled
+	ok, err := store.GetJSON(key, &disabled)
+	if err != nil || !ok {
+		return err
+	}
+	if len(disabled.Channels) == 0 {
+		return NewUserWarning("it's disabled in the whole server, enable it everywhere first")
+	}
+	if disabled.Channels = removeStrings(disabled.Channels, channels...); len(disabled.Channels) == 0 {
+		return store.Delete(key)
+	}
+	return store.SetJSON(key, &disabled, 0)
+}
+
+// DisableGuildCommand disables the command (along with all of it's subcommands) in the guild channels or in the
+// whole guild if no channels are given.
+func (sg *Instance) DisableGuildCommand(guildID string, path string, channels ...string) error {
+	return sg.disableInGuild(guildID, guildCommandKey(path), channels)
+}
+
+// EnableGuildCommand enables the command disabled in the guild channels or in the whole guild if no channels are
+// given.
+func (sg *Instance) EnableGuildCommand(guildID string, path string, channels ...string) error {
+	return sg.enableInGuild(guildID, guildCommandKey(path), channels)
+}
+
+// DisableGuildModule disables all the commands of the module in the guild channels or in the whole guild if no
+// channels are given.
+func (sg *Instance) DisableGuildModule(guildID string, module string, channels ...string) error {
+	return sg.disableInGuild(guildID, guildModuleKey(module), channels)
+}
+
+// EnableGuildModule enables the module disabled in the guild channels or in the whole guild if no channels are given.
+func (sg *Instance) EnableGuildModule(guildID string, module string, channels ...string) error {
+	return sg.enableInGuild(guildID, guildModuleKey(module), channels)
+}
+
+// isDisabledInChannel returns true if the command, any of it's parents or it's module is disabled in the guild
+// channel.
+func (sg *Instance) isDisabledInChannel(channel *discordgo.Channel, c *Command) (bool, error) {
+	if channel.GuildID == "" {
+		return false, nil
+	}
+	store := sg.guildCommandsStore(channel.GuildID)
+
+	keys := []string{}
+	if module := c.GetModule(); module != "" {
+		keys = append(keys, guildModuleKey(module))
+	}
+	for cmd := c; cmd != nil; cmd = cmd.parent {
+		keys = append(keys, guildCommandKey(cmd.GetPath()))
+	}
+
+	for _, key := range keys {
+		var disabled guildDisabled
+		ok, err := store.GetJSON(key, &disabled)
+		if err != nil {
+			return false, errors.Wrap(err, "unable to check if command is disabled")
+		}
+		if ok && disabled.covers(channel.ID) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// formatGuildDisabled describes where the command or module under the key is disabled in the guild.
+func (sg *Instance) formatGuildDisabled(guildID string, key string) (string, error) {
+	var disabled guildDisabled
+	ok, err := sg.guildCommandsStore(guildID).GetJSON(key, &disabled)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case !ok:
+		return "enabled", nil
+	case len(disabled.Channels) == 0:
+		return "**disabled**", nil
+	}
+	var channels []string
+	for _, channelID := range disabled.Channels {
+		channels = append(channels, "<#"+channelID+">")
+	}
+	return "disabled in " + strings.Join(channels, " "), nil
+}
+
+// CommandsCommand makes the command that allows guild administrators to enable and disable commands and whole
+// modules for their guild or specific channels. The command is not added automatically, use AddCommand to add it.
+func (sg *Instance) CommandsCommand() *Command {
+	var self *Command
+
+	// toggle enables or disables the command or module given in the Request query.
+	toggle := func(req *Request, enable bool) (*Response, error) {
+		var params, channels []string
+		for _, param := range strings.Fields(req.Query) {
+			if channelID := parseChannelID(param); channelID != "" {
+				channels = append(channels, channelID)
+			} else {
+				params = append(params, param)
+			}
+		}
+		if len(params) == 0 {
+			return nil, NewUserWarning("please, specify the command or module")
+		}
+		target := strings.Join(params, " ")
+
+		var key string
+		if cmd := sg.GetCommandByPath(target); cmd != nil {
+			// Make sure guild administrators do not lock themselves out.
+			if !enable && (cmd.isAncestorOf(self) || self.isAncestorOf(cmd)) {
+				return nil, NewUserWarning("this command can not be disabled")
+			}
+			key = guildCommandKey(cmd.GetPath())
+		} else if containsString(sg.Modules(), target) {
+			if !enable && target == self.GetModule() {
+				return nil, NewUserWarning("this module can not be disabled")
+			}
+			key = guildModuleKey(target)
+		} else {
+			return nil, NewNotFoundError("command or module " + target)
+		}
+
+		var err error
+		if enable {
+			err = sg.enableInGuild(req.Channel.GuildID, key, channels)
+		} else {
+			err = sg.disableInGuild(req.Channel.GuildID, key, channels)
+		}
+		if err != nil {
+			return nil, err
+		}
+		state, err := sg.formatGuildDisabled(req.Channel.GuildID, key)
+		if err != nil {
+			return nil, err
+		}
+		return req.NewResponse(ResponseSuccess, target, state), nil
+	}
+
+	self = &Command{
+		Trigger:             "commands",
+		Description:         "enables and disables commands and modules in this guild",
+		PermissionsRequired: discordgo.PermissionManageServer,
+		RequireGuild:        true,
+		SubCommands: []*Command{
+			{
+				Trigger:     "list",
+				Description: "lists all the commands and modules along with where they are disabled",
+				Execute: func(req *Request) (*Response, error) {
+					// Owner only commands (and modules consisting of them) are not shown to anyone else.
+					var commands []*Command
+					var modules []string
+					for _, cmd := range sg.Commands() {
+						if cmd.isOwnerOnly() && !req.IsOwner() {
+							continue
+						}
+						commands = append(commands, cmd)
+						if cmd.Module != "" {
+							modules = appendUnique(modules, cmd.Module)
+						}
+					}
+					sort.Strings(modules)
+
+					var lines []string
+					for _, module := range modules {
+						state, err := sg.formatGuildDisabled(req.Channel.GuildID, guildModuleKey(module))
+						if err != nil {
+							return nil, err
+						}
+						lines = append(lines, "module **"+module+"**: "+state)
+					}
+					for _, cmd := range commands {
+						state, err := sg.formatGuildDisabled(req.Channel.GuildID, guildCommandKey(cmd.GetPath()))
+						if err != nil {
+							return nil, err
+						}
+						lines = append(lines, "`"+cmd.GetPath()+"`: "+state)
+					}
+					return req.NewResponse(ResponseInfo, "commands", truncate(strings.Join(lines, "\n"), 2000)), nil
+				},
+			},
+			{
+				Trigger:     "enable",
+				Description: "enables the command or module: commands enable <command|module> [#channel]...",
+				HasParams:   true,
+				Execute: func(req *Request) (*Response, error) {
+					return toggle(req, true)
+				},
+			},
+			{
+				Trigger:     "disable",
+				Description: "disables the command or module: commands disable <command|module> [#channel]...",
+				HasParams:   true,
+				Execute: func(req *Request) (*Response, error) {
+					return toggle(req, false)
+				},
+			},
+		},
+	}
+	return self
+}
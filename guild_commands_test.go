@@ -0,0 +1,94 @@
+package sugo
+
+import (
+	"github.com/bwmarrin/discordgo"
+	"testing"
+)
+
+func TestIsDisabledInChannel(t *testing.T) {
+	tests := []struct {
+		name    string
+		disable func(sg *Instance) error
+		channel *discordgo.Channel
+		want    bool
+	}{
+		{
+			name:    "nothing disabled",
+			disable: func(sg *Instance) error { return nil },
+			want:    false,
+		},
+		{
+			name:    "command disabled in guild",
+			disable: func(sg *Instance) error { return sg.DisableGuildCommand("guild", "games dice") },
+			want:    true,
+		},
+		{
+			name:    "parent disabled in channel",
+			disable: func(sg *Instance) error { return sg.DisableGuildCommand("guild", "games", "channel") },
+			want:    true,
+		},
+		{
+			name:    "parent disabled in other channel",
+			disable: func(sg *Instance) error { return sg.DisableGuildCommand("guild", "games", "other") },
+			want:    false,
+		},
+		{
+			name:    "sibling disabled",
+			disable: func(sg *Instance) error { return sg.DisableGuildCommand("guild", "games coin") },
+			want:    false,
+		},
+		{
+			name:    "module disabled",
+			disable: func(sg *Instance) error { return sg.DisableGuildModule("guild", "fun") },
+			want:    true,
+		},
+		{
+			name: "enabled back in channel",
+			disable: func(sg *Instance) error {
+				if err := sg.DisableGuildCommand("guild", "games", "channel", "other"); err != nil {
+					return err
+				}
+				return sg.EnableGuildCommand("guild", "games", "channel")
+			},
+			want: false,
+		},
+		{
+			name:    "disabled in other guild",
+			disable: func(sg *Instance) error { return sg.DisableGuildModule("other", "fun") },
+			want:    false,
+		},
+		{
+			name:    "direct messages",
+			disable: func(sg *Instance) error { return sg.DisableGuildModule("guild", "fun") },
+			channel: &discordgo.Channel{ID: "channel"},
+			want:    false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sg := New()
+			execute := func(req *Request) (*Response, error) { return nil, nil }
+			dice := &Command{Trigger: "dice", Execute: execute}
+			sg.AddCommand(&Command{
+				Trigger:     "games",
+				Module:      "fun",
+				SubCommands: []*Command{dice, {Trigger: "coin", Execute: execute}},
+			})
+			if err := tt.disable(sg); err != nil {
+				t.Fatal(err)
+			}
+
+			channel := tt.channel
+			if channel == nil {
+				channel = &discordgo.Channel{ID: "channel", GuildID: "guild"}
+			}
+			got, err := sg.isDisabledInChannel(channel, dice)
+			if err != nil {
+				t.Fatalf("isDisabledInChannel() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("isDisabledInChannel() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
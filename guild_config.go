@@ -0,0 +1,435 @@
+package sugo
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// guildConfigFormat identifies guild configuration files.
+	guildConfigFormat = "sugo-guild-config"
+	// guildConfigVersion is the current version of the guild configuration file format.
+	guildConfigVersion = 1
+	// maxGuildConfigSize is the maximum size of the guild configuration file that can be imported.
+	maxGuildConfigSize = 8 * 1024 * 1024
+	// pendingImportTTL is the time guild configuration import waits for the confirmation.
+	pendingImportTTL = 10 * time.Minute
+	// guildConfigImportsNamespace is the storage namespace of the pending guild configuration imports.
+	guildConfigImportsNamespace = "sugo.config_imports"
+)
+
+// guildConfigClient downloads guild configuration files.
+var guildConfigClient = &http.Client{Timeout: 30 * time.Second}
+
+// GuildConfig is the exported configuration of the guild: all the guild scoped data of the storage namespaces listed
+// in GuildConfigNamespaces.
+type GuildConfig struct {
+	Format      string    `json:"format"`
+	Version     int       `json:"version"`
+	SugoVersion string    `json:"sugo_version"`
+	GuildID     string    `json:"guild_id"`
+	ExportedAt  time.Time `json:"exported_at"`
+	// Namespaces contains values by namespace and key. Keys are relative: "guild/<key>" for guild scoped values and
+	// "channel/<channel ID>/<key>" for channel scoped ones.
+	Namespaces map[string]map[string][]byte `json:"namespaces"`
+}
+
+// validate makes sure configuration is something we can import.
+func (c *GuildConfig) validate() error {
+	if c.Format != guildConfigFormat {
+		return NewUserWarning("this is not a bot configuration file")
+	}
+	if c.Version <= 0 || c.Version > guildConfigVersion {
+		return NewUserWarning("configuration file version " + strconv.Itoa(c.Version) + " is not supported")
+	}
+	for namespace, values := range c.Namespaces {
+		if namespace == "" {
+			return NewUserWarning("configuration file contains empty namespace")
+		}
+		for key := range values {
+			if !strings.HasPrefix(key, "guild/") && !strings.HasPrefix(key, "channel/") {
+				return NewUserWarning("configuration file contains invalid key: " + namespace + " " + key)
+			}
+		}
+	}
+	return nil
+}
+
+// pendingImport is the guild configuration import waiting for the confirmation. Pending imports are kept in the
+// storage by guild ID and expire in pendingImportTTL.
+type pendingImport struct {
+	UserID string       `json:"user_id"`
+	Config *GuildConfig `json:"config"`
+}
+
+// guildChannels returns the guild channels from the state or, if the guild is not there, requests them from discord.
+func (sg *Instance) guildChannels(guildID string) ([]*discordgo.Channel, error) {
+	if guild, err := sg.Session.State.Guild(guildID); err == nil {
+		return guild.Channels, nil
+	}
+	channels, err := sg.Session.GuildChannels(guildID)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get guild channels")
+	}
+	return channels, nil
+}
+
+// guildChannelPrefixes returns storage key prefixes of the guild channels scoped values.
+func (sg *Instance) guildChannelPrefixes(guildID string) (map[string]bool, error) {
+	channels, err := sg.guildChannels(guildID)
+	if err != nil {
+		return nil, err
+	}
+	prefixes := map[string]bool{}
+	for _, channel := range channels {
+		prefixes["channel/"+channel.ID+"/"] = true
+	}
+	return prefixes, nil
+}
+
+// ExportGuildConfig collects all the guild and guild channels scoped values of the storage namespaces listed in
+// GuildConfigNamespaces.
+func (sg *Instance) ExportGuildConfig(guildID string) (*GuildConfig, error) {
+	config := &GuildConfig{
+		Format:      guildConfigFormat,
+		Version:     guildConfigVersion,
+		SugoVersion: VERSION,
+		GuildID:     guildID,
+		ExportedAt:  time.Now().UTC(),
+		Namespaces:  map[string]map[string][]byte{},
+	}
+
+	// Guild scoped values.
+	dump, err := dumpStorage(sg.storage(), guildStorePrefix(guildID))
+	if err != nil {
+		return nil, err
+	}
+	for namespace, values := range dump {
+		if !containsString(sg.GuildConfigNamespaces, namespace) {
+			continue
+		}
+		for key, value := range values {
+			config.set(namespace, "guild/"+strings.TrimPrefix(key, guildStorePrefix(guildID)), value)
+		}
+	}
+
+	// Guild channels scoped values.
+	prefixes, err := sg.guildChannelPrefixes(guildID)
+	if err != nil {
+		return nil, err
+	}
+	dump, err = dumpStorage(sg.storage(), "channel/")
+	if err != nil {
+		return nil, err
+	}
+	for namespace, values := range dump {
+		if !containsString(sg.GuildConfigNamespaces, namespace) {
+			continue
+		}
+		for key, value := range values {
+			if prefixes[channelKeyPrefix(key)] {
+				config.set(namespace, key, value)
+			}
+		}
+	}
+
+	return config, nil
+}
+
+// set sets the config value.
+func (c *GuildConfig) set(namespace string, key string, value []byte) {
+	if c.Namespaces[namespace] == nil {
+		c.Namespaces[namespace] = map[string][]byte{}
+	}
+	c.Namespaces[namespace][key] = value
+}
+
+// channelKeyPrefix returns "channel/<channel ID>/" prefix of the channel scoped key.
+func channelKeyPrefix(key string) string {
+	parts := strings.SplitN(key, "/", 3)
+	if len(parts) < 3 {
+		return ""
+	}
+	return parts[0] + "/" + parts[1] + "/"
+}
+
+// storageKey converts relative config key into the storage key for the given guild.
+func (c *GuildConfig) storageKey(guildID string, key string) string {
+	if strings.HasPrefix(key, "guild/") {
+		return guildStorePrefix(guildID) + strings.TrimPrefix(key, "guild/")
+	}
+	return key
+}
+
+// GuildConfigDiff describes changes importing of the configuration makes.
+type GuildConfigDiff struct {
+	// Added, Changed and Removed contain "<namespace> <key>" strings in sorted order.
+	Added   []string
+	Changed []string
+	Removed []string
+	// Skipped contains values of the namespaces that are not in GuildConfigNamespaces and channel scoped values of
+	// the channels that do not exist in the guild, sorted by item.
+	Skipped []SkippedConfigValue
+}
+
+// SkippedConfigValue is the configuration value import skips.
+type SkippedConfigValue struct {
+	// Item is "<namespace> <key>" string.
+	Item string
+	// Reason explains why the value is skipped.
+	Reason string
+}
+
+// Empty returns true if import changes nothing.
+func (d *GuildConfigDiff) Empty() bool {
+	return len(d.Added)+len(d.Changed)+len(d.Removed) == 0
+}
+
+// DiffGuildConfig compares the configuration with the current guild one.
+func (sg *Instance) DiffGuildConfig(guildID string, config *GuildConfig) (*GuildConfigDiff, error) {
+	current, err := sg.ExportGuildConfig(guildID)
+	if err != nil {
+		return nil, err
+	}
+	prefixes, err := sg.guildChannelPrefixes(guildID)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &GuildConfigDiff{}
+	for namespace, values := range config.Namespaces {
+		for key, value := range values {
+			if !containsString(sg.GuildConfigNamespaces, namespace) {
+				diff.Skipped = append(diff.Skipped, SkippedConfigValue{namespace + " " + key, "namespace is not imported"})
+				continue
+			}
+			if strings.HasPrefix(key, "channel/") && !prefixes[channelKeyPrefix(key)] {
+				diff.Skipped = append(diff.Skipped, SkippedConfigValue{namespace + " " + key, "channel not found"})
+				continue
+			}
+			currentValue, ok := current.Namespaces[namespace][key]
+			switch {
+			case !ok:
+				diff.Added = append(diff.Added, namespace+" "+key)
+			case !bytes.Equal(currentValue, value):
+				diff.Changed = append(diff.Changed, namespace+" "+key)
+			}
+		}
+	}
+	for namespace, values := range current.Namespaces {
+		for key := range values {
+			if _, ok := config.Namespaces[namespace][key]; !ok {
+				diff.Removed = append(diff.Removed, namespace+" "+key)
+			}
+		}
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Changed)
+	sort.Strings(diff.Removed)
+	sort.Slice(diff.Skipped, func(i, j int) bool { return diff.Skipped[i].Item < diff.Skipped[j].Item })
+	return diff, nil
+}
+
+// ImportGuildConfig replaces the guild configuration with the given one: values missing from the configuration are
+// removed, values of the namespaces not listed in GuildConfigNamespaces and channel scoped values of the channels that
+// do not exist in the guild are skipped.
+func (sg *Instance) ImportGuildConfig(guildID string, config *GuildConfig) error {
+	if err := config.validate(); err != nil {
+		return err
+	}
+	diff, err := sg.DiffGuildConfig(guildID, config)
+	if err != nil {
+		return err
+	}
+
+	storage := sg.storage()
+	for _, item := range append(diff.Added, diff.Changed...) {
+		parts := strings.SplitN(item, " ", 2)
+		value := config.Namespaces[parts[0]][parts[1]]
+		if err = storage.Set(parts[0], config.storageKey(guildID, parts[1]), value, 0); err != nil {
+			return errors.Wrap(err, "unable to import "+item)
+		}
+	}
+	for _, item := range diff.Removed {
+		parts := strings.SplitN(item, " ", 2)
+		if err = storage.Delete(parts[0], config.storageKey(guildID, parts[1])); err != nil {
+			return errors.Wrap(err, "unable to remove "+item)
+		}
+	}
+	return nil
+}
+
+// downloadGuildConfig downloads and parses the configuration file attached to the Request message.
+func downloadGuildConfig(req *Request) (*GuildConfig, error) {
+	if len(req.Message.Attachments) != 1 {
+		return nil, NewUserWarning("please, attach exactly one configuration file to the message")
+	}
+	if req.Message.Attachments[0].Size > maxGuildConfigSize {
+		return nil, NewUserWarning("configuration file is too big")
+	}
+
+	httpReq, err := http.NewRequest(http.MethodGet, req.Message.Attachments[0].URL, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to make attachment request")
+	}
+	httpResp, err := guildConfigClient.Do(httpReq.WithContext(req.Ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to download attachment")
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, errors.New("unable to download attachment: " + httpResp.Status)
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(httpResp.Body, maxGuildConfigSize+1))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to download attachment")
+	}
+	if len(b) > maxGuildConfigSize {
+		return nil, NewUserWarning("configuration file is too big")
+	}
+
+	config := &GuildConfig{}
+	if err = json.Unmarshal(b, config); err != nil {
+		return nil, NewUserWarning("configuration file is not valid JSON")
+	}
+	if err = config.validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// formatGuildConfigDiff renders the diff as the preview text.
+func formatGuildConfigDiff(diff *GuildConfigDiff) string {
+	var lines []string
+	for _, item := range diff.Added {
+		lines = append(lines, "+ "+item)
+	}
+	for _, item := range diff.Changed {
+		lines = append(lines, "~ "+item)
+	}
+	for _, item := range diff.Removed {
+		lines = append(lines, "- "+item)
+	}
+	for _, item := range diff.Skipped {
+		lines = append(lines, "? "+item.Item+" ("+item.Reason+", skipped)")
+	}
+	return "```diff\n" + truncate(strings.Join(lines, "\n"), 1700) + "\n```"
+}
+
+// GuildConfigCommand makes the command that allows guild administrators to export the guild configuration as a JSON
+// file and import it back (into the same or another guild). The command is not added automatically, use AddCommand to
+// add it.
+func (sg *Instance) GuildConfigCommand() *Command {
+	imports := sg.Store(guildConfigImportsNamespace)
+
+	return &Command{
+		Trigger:             "config",
+		Description:         "exports and imports bot configuration of the guild",
+		PermissionsRequired: discordgo.PermissionManageServer,
+		RequireGuild:        true,
+		SubCommands: []*Command{
+			{
+				Trigger:     "export",
+				Description: "exports bot configuration of the guild as a file",
+				Execute: func(req *Request) (*Response, error) {
+					config, err := sg.ExportGuildConfig(req.Channel.GuildID)
+					if err != nil {
+						return nil, err
+					}
+					b, err := json.MarshalIndent(config, "", "  ")
+					if err != nil {
+						return nil, errors.Wrap(err, "unable to marshal guild configuration")
+					}
+					resp := req.NewResponse(ResponseSuccess, "config", "here is the bot configuration of the guild")
+					resp.Files = []*discordgo.File{{
+						Name:        "config-" + req.Channel.GuildID + "-" + config.ExportedAt.Format("20060102-150405") + ".json",
+						ContentType: "application/json",
+						Reader:      bytes.NewReader(b),
+					}}
+					return resp, nil
+				},
+			},
+			{
+				Trigger:     "import",
+				Description: "previews import of the attached configuration file, use import confirm to apply it",
+				Execute: func(req *Request) (*Response, error) {
+					config, err := downloadGuildConfig(req)
+					if err != nil {
+						return nil, err
+					}
+					diff, err := sg.DiffGuildConfig(req.Channel.GuildID, config)
+					if err != nil {
+						return nil, err
+					}
+					if diff.Empty() {
+						return req.NewResponse(ResponseInfo, "config", "configuration is the same, nothing to import"), nil
+					}
+
+					pending := &pendingImport{UserID: req.Message.Author.ID, Config: config}
+					if err = imports.SetJSON(req.Channel.GuildID, pending, pendingImportTTL); err != nil {
+						return nil, errors.Wrap(err, "unable to store pending import")
+					}
+
+					return req.NewResponse(ResponseWarning, "config import preview", formatGuildConfigDiff(diff)+
+						"\nuse `config import confirm` to apply or `config import cancel` to discard"), nil
+				},
+				SubCommands: []*Command{
+					{
+						Trigger:     "confirm",
+						Description: "applies previewed configuration import",
+						Execute: func(req *Request) (*Response, error) {
+							// Take the import atomically, so it's applied once however many times it's confirmed.
+							var pending *pendingImport
+							err := imports.Update(req.Channel.GuildID, KeepTTL, func(value []byte, ok bool) ([]byte, error) {
+								if !ok {
+									return nil, nil
+								}
+								p := &pendingImport{}
+								if err := json.Unmarshal(value, p); err != nil {
+									return nil, errors.Wrap(err, "unable to unmarshal pending import")
+								}
+								if p.UserID != req.Message.Author.ID {
+									return value, nil
+								}
+								pending = p
+								return nil, nil
+							})
+							if err != nil {
+								return nil, err
+							}
+							if pending == nil {
+								return nil, NewUserWarning("there is no configuration import of yours to confirm")
+							}
+
+							if err := sg.ImportGuildConfig(req.Channel.GuildID, pending.Config); err != nil {
+								return nil, err
+							}
+							return req.NewResponse(ResponseSuccess, "config", "configuration imported"), nil
+						},
+					},
+					{
+						Trigger:     "cancel",
+						Description: "discards previewed configuration import",
+						Execute: func(req *Request) (*Response, error) {
+							if err := imports.Delete(req.Channel.GuildID); err != nil {
+								return nil, errors.Wrap(err, "unable to discard pending import")
+							}
+							return req.NewResponse(ResponseSuccess, "config", "configuration import discarded"), nil
+						},
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,157 @@
+package sugo
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"github.com/bwmarrin/discordgo"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// healthStatus is the body of the health and readiness endpoints responses.
+type healthStatus struct {
+	Status             string  `json:"status"`
+	State              string  `json:"state"`
+	Version            string  `json:"version"`
+	GatewayConnected   bool    `json:"gateway_connected"`
+	SecondsSinceEvent  float64 `json:"seconds_since_last_event"`
+	UptimeSeconds      float64 `json:"uptime_seconds"`
+	RegisteredCommands int     `json:"registered_commands"`
+}
+
+// commandStatus describes the command in the admin commands list.
+type commandStatus struct {
+	Path        string `json:"path"`
+	Description string `json:"description"`
+	Disabled    bool   `json:"disabled"`
+}
+
+// trackGateway registers discord session handlers that keep track of the gateway connection state and last event
+// time.
+func (sg *Instance) trackGateway() {
+	sg.Session.AddHandler(func(s *discordgo.Session, e *discordgo.Connect) {
+		atomic.StoreInt32(&sg.gatewayConnected, 1)
+	})
+	sg.Session.AddHandler(func(s *discordgo.Session, e *discordgo.Disconnect) {
+		atomic.StoreInt32(&sg.gatewayConnected, 0)
+	})
+	sg.Session.AddHandler(func(s *discordgo.Session, e *discordgo.Event) {
+		atomic.StoreInt64(&sg.lastEventAt, time.Now().UnixNano())
+	})
+}
+
+// healthStatus collects the bot health details.
+func (sg *Instance) healthStatus() healthStatus {
+	status := healthStatus{
+		State:              sg.State().String(),
+		Version:            VERSION,
+		GatewayConnected:   atomic.LoadInt32(&sg.gatewayConnected) == 1,
+		RegisteredCommands: len(sg.Commands()),
+	}
+	if lastEventAt := atomic.LoadInt64(&sg.lastEventAt); lastEventAt != 0 {
+		status.SecondsSinceEvent = time.Since(time.Unix(0, lastEventAt)).Seconds()
+	}
+	if startedAt := atomic.LoadInt64(&sg.startedAt); startedAt != 0 {
+		status.UptimeSeconds = time.Since(time.Unix(0, startedAt)).Seconds()
+	}
+	return status
+}
+
+// registerHealthRoutes registers liveness, readiness and (if AdminSecret is set) admin routes.
+func (sg *Instance) registerHealthRoutes(mux *http.ServeMux) {
+	// Liveness: bot process is alive and serves requests.
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		status := sg.healthStatus()
+		status.Status = "ok"
+		writeJSON(w, http.StatusOK, status)
+	})
+
+	// Readiness: bot is running and connected to the gateway.
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		status := sg.healthStatus()
+		if sg.State() == StateRunning && status.GatewayConnected {
+			status.Status = "ready"
+			writeJSON(w, http.StatusOK, status)
+			return
+		}
+		status.Status = "not ready"
+		writeJSON(w, http.StatusServiceUnavailable, status)
+	})
+
+	// Admin routes are only available if the secret is configured.
+	if sg.AdminSecret == "" {
+		return
+	}
+
+	mux.HandleFunc("/admin/commands", sg.adminOnly(http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+		var list []commandStatus
+		for _, cmd := range sg.Commands() {
+			list = append(list, commandStatus{
+				Path:        cmd.GetPath(),
+				Description: cmd.Description,
+				Disabled:    cmd.IsDisabled(),
+			})
+		}
+		writeJSON(w, http.StatusOK, list)
+	}))
+	mux.HandleFunc("/admin/commands/enable", sg.adminOnly(http.MethodPost, func(w http.ResponseWriter, r *http.Request) {
+		sg.adminToggleCommand(w, r, false)
+	}))
+	mux.HandleFunc("/admin/commands/disable", sg.adminOnly(http.MethodPost, func(w http.ResponseWriter, r *http.Request) {
+		sg.adminToggleCommand(w, r, true)
+	}))
+	mux.HandleFunc("/admin/maintenance", sg.adminOnly("", sg.adminMaintenance))
+	mux.HandleFunc("/admin/shutdown", sg.adminOnly(http.MethodPost, func(w http.ResponseWriter, r *http.Request) {
+		sg.logger().Info("shutdown requested via admin http endpoint", "remote_addr", r.RemoteAddr)
+		writeJSON(w, http.StatusAccepted, map[string]string{"status": "shutting down"})
+		sg.Shutdown()
+	}))
+}
+
+// adminToggleCommand enables or disables the command given by "path" query parameter.
+func (sg *Instance) adminToggleCommand(w http.ResponseWriter, r *http.Request, disable bool) {
+	cmd := sg.GetCommandByPath(r.URL.Query().Get("path"))
+	if cmd == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "command not found"})
+		return
+	}
+
+	if disable {
+		cmd.Disable()
+	} else {
+		cmd.Enable()
+	}
+	sg.logger().Info("command toggled via admin http endpoint", "command", cmd.GetPath(), "disabled", disable)
+
+	writeJSON(w, http.StatusOK, commandStatus{
+		Path:        cmd.GetPath(),
+		Description: cmd.Description,
+		Disabled:    cmd.IsDisabled(),
+	})
+}
+
+// adminOnly makes sure request has the right method (any if empty) and carries the admin secret as a bearer token.
+func (sg *Instance) adminOnly(method string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		token := strings.TrimPrefix(header, "Bearer ")
+		if token == header || subtle.ConstantTimeCompare([]byte(token), []byte(sg.AdminSecret)) != 1 {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+		if method != "" && r.Method != method {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+		h(w, r)
+	}
+}
+
+// writeJSON writes the value as JSON response with the given status code.
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,48 @@
+package sugo
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+// httpShutdownTimeout is the time HTTP server is given to finish serving requests on bot shutdown.
+const httpShutdownTimeout = 5 * time.Second
+
+// startHTTPServer starts the local HTTP server if HTTPAddr is set. Server is stopped as soon as the context is
+// cancelled.
+func (sg *Instance) startHTTPServer(ctx context.Context) error {
+	if sg.HTTPAddr == "" {
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", sg.metrics())
+	sg.registerHealthRoutes(mux)
+
+	// Listen right away, so we fail the startup if the address is unavailable.
+	listener, err := net.Listen("tcp", sg.HTTPAddr)
+	if err != nil {
+		return errors.Wrap(err, "unable to start http server")
+	}
+
+	server := &http.Server{Handler: mux}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			sg.HandleError(nil, errors.Wrap(err, "http server error"))
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			sg.HandleError(nil, errors.Wrap(err, "http server shutdown error"))
+		}
+	}()
+
+	sg.logger().Info("http server is listening", "addr", listener.Addr().String())
+	return nil
+}
@@ -16,8 +16,9 @@ func (sg *Instance) isTriggered(req *Request) (triggered bool) {
 		// it to be command without any further checks for prefixes.
 		triggered = true
 		return
-	} else if req.Channel.Type == discordgo.ChannelTypeGuildText || req.Channel.Type == discordgo.ChannelTypeGroupDM {
-		// It's either Guild Text Channel or multiple people direct group Channel.
+	} else if req.Channel.Type == discordgo.ChannelTypeGuildText || req.Channel.Type == ChannelTypeGuildNews ||
+		req.Channel.Type == discordgo.ChannelTypeGroupDM {
+		// It's either Guild Text or News Channel or multiple people direct group Channel.
 		// In order to detect command we need to check for bot Trigger.
 
 		// If bot Trigger is set and command starts with that Trigger:
@@ -0,0 +1,61 @@
+package sugo
+
+import "context"
+
+// State describes the lifecycle state of the bot Instance.
+type State int
+
+const (
+	// StateCreated is the state of the freshly created Instance that was never run.
+	StateCreated State = iota
+	// StateStarting means Instance is connecting to discord and running startup handlers.
+	StateStarting
+	// StateRunning means Instance is connected to discord and processes messages.
+	StateRunning
+	// StateStopping means Instance is running shutdown handlers and closing discord session.
+	StateStopping
+	// StateStopped means Instance has finished running and can be run again.
+	StateStopped
+)
+
+// String returns human-readable state name.
+func (s State) String() string {
+	switch s {
+	case StateCreated:
+		return "created"
+	case StateStarting:
+		return "starting"
+	case StateRunning:
+		return "running"
+	case StateStopping:
+		return "stopping"
+	case StateStopped:
+		return "stopped"
+	}
+	return "unknown"
+}
+
+// State returns current lifecycle state of the Instance.
+func (sg *Instance) State() State {
+	sg.mu.Lock()
+	defer sg.mu.Unlock()
+	return sg.state
+}
+
+// setState changes current lifecycle state of the Instance.
+func (sg *Instance) setState(state State) {
+	sg.mu.Lock()
+	defer sg.mu.Unlock()
+	sg.state = state
+}
+
+// Context returns the context Instance is currently running with. Context is cancelled as soon as the Instance
+// starts shutting down. If Instance is not running, context.Background() is returned.
+func (sg *Instance) Context() context.Context {
+	sg.mu.Lock()
+	defer sg.mu.Unlock()
+	if sg.ctx == nil {
+		return context.Background()
+	}
+	return sg.ctx
+}
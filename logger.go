@@ -0,0 +1,221 @@
+package sugo
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogLevel is the severity of the log event.
+type LogLevel int
+
+const (
+	// LogLevelDebug is used for the events that help to trace Request processing.
+	LogLevelDebug LogLevel = iota
+	// LogLevelInfo is used for the regular bot lifecycle events.
+	LogLevelInfo
+	// LogLevelWarn is used for the events that are unexpected but do not break anything.
+	LogLevelWarn
+	// LogLevelError is used for the errors.
+	LogLevelError
+)
+
+// String returns human-readable level name.
+func (l LogLevel) String() string {
+	switch l {
+	case LogLevelDebug:
+		return "debug"
+	case LogLevelInfo:
+		return "info"
+	case LogLevelWarn:
+		return "warn"
+	case LogLevelError:
+		return "error"
+	}
+	return "unknown"
+}
+
+// Logger is a structured logger. Every method accepts the message and the list of alternating keys and values, keys
+// must be strings.
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Warn(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+}
+
+// JSONLogger writes every log event as a single line JSON object.
+type JSONLogger struct {
+	// Level is the minimum level of the events to be written.
+	Level LogLevel
+
+	// mu makes sure events are not interleaved.
+	mu sync.Mutex
+	// w is where events are written to.
+	w io.Writer
+}
+
+// NewJSONLogger creates JSONLogger that writes events of the given level and above into w.
+func NewJSONLogger(w io.Writer, level LogLevel) *JSONLogger {
+	return &JSONLogger{w: w, Level: level}
+}
+
+// Debug writes debug event.
+func (l *JSONLogger) Debug(msg string, keyvals ...interface{}) {
+	l.log(LogLevelDebug, msg, keyvals)
+}
+
+// Info writes info event.
+func (l *JSONLogger) Info(msg string, keyvals ...interface{}) {
+	l.log(LogLevelInfo, msg, keyvals)
+}
+
+// Warn writes warning event.
+func (l *JSONLogger) Warn(msg string, keyvals ...interface{}) {
+	l.log(LogLevelWarn, msg, keyvals)
+}
+
+// Error writes error event.
+func (l *JSONLogger) Error(msg string, keyvals ...interface{}) {
+	l.log(LogLevelError, msg, keyvals)
+}
+
+// log writes the event as a JSON object.
+func (l *JSONLogger) log(level LogLevel, msg string, keyvals []interface{}) {
+	if level < l.Level {
+		return
+	}
+
+	event := map[string]interface{}{}
+	for i := 0; i < len(keyvals); i += 2 {
+		event[logKey(keyvals[i])] = logValue(keyvals, i+1)
+	}
+	event["time"] = time.Now().Format(time.RFC3339Nano)
+	event["level"] = level.String()
+	event["msg"] = msg
+
+	b, err := json.Marshal(event)
+	if err != nil {
+		b, _ = json.Marshal(map[string]string{"level": LogLevelError.String(), "msg": "unable to marshal log event: " + err.Error()})
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = l.w.Write(append(b, '\n'))
+}
+
+// StdLogger is an adapter that writes events into the standard library *log.Logger as logfmt-like lines.
+type StdLogger struct {
+	// Level is the minimum level of the events to be written.
+	Level LogLevel
+
+	// l is the underlying logger.
+	l *log.Logger
+}
+
+// NewStdLogger creates StdLogger that writes events of the given level and above into l.
+func NewStdLogger(l *log.Logger, level LogLevel) *StdLogger {
+	return &StdLogger{l: l, Level: level}
+}
+
+// Debug writes debug event.
+func (l *StdLogger) Debug(msg string, keyvals ...interface{}) {
+	l.log(LogLevelDebug, msg, keyvals)
+}
+
+// Info writes info event.
+func (l *StdLogger) Info(msg string, keyvals ...interface{}) {
+	l.log(LogLevelInfo, msg, keyvals)
+}
+
+// Warn writes warning event.
+func (l *StdLogger) Warn(msg string, keyvals ...interface{}) {
+	l.log(LogLevelWarn, msg, keyvals)
+}
+
+// Error writes error event.
+func (l *StdLogger) Error(msg string, keyvals ...interface{}) {
+	l.log(LogLevelError, msg, keyvals)
+}
+
+// log writes the event as a single line.
+func (l *StdLogger) log(level LogLevel, msg string, keyvals []interface{}) {
+	if level < l.Level {
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString(strings.ToUpper(level.String()))
+	b.WriteString(" ")
+	b.WriteString(msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		fmt.Fprintf(&b, " %s=%q", logKey(keyvals[i]), fmt.Sprint(logValue(keyvals, i+1)))
+	}
+	l.l.Println(b.String())
+}
+
+// logKey converts key to string.
+func logKey(key interface{}) string {
+	if s, ok := key.(string); ok {
+		return s
+	}
+	return fmt.Sprint(key)
+}
+
+// logValue returns value at the given position or placeholder if there is none. Errors and durations are converted
+// to strings for them to be readable in JSON.
+func logValue(keyvals []interface{}, i int) interface{} {
+	if i >= len(keyvals) {
+		return "(missing)"
+	}
+	switch v := keyvals[i].(type) {
+	case error:
+		return v.Error()
+	case time.Duration:
+		return v.String()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return keyvals[i]
+}
+
+// defaultLogger is used if Instance has no Logger set.
+var defaultLogger = NewJSONLogger(os.Stderr, LogLevelInfo)
+
+// logger returns the Instance logger.
+func (sg *Instance) logger() Logger {
+	if sg.Logger == nil {
+		return defaultLogger
+	}
+	return sg.Logger
+}
+
+// logFields returns the Request details to be logged along with the Request related events.
+func (req *Request) logFields(keyvals ...interface{}) []interface{} {
+	if req == nil {
+		return keyvals
+	}
+
+	fields := []interface{}{"request_id", req.ID}
+	if req.Channel != nil {
+		if req.Channel.GuildID != "" {
+			fields = append(fields, "guild", req.Channel.GuildID)
+		}
+		fields = append(fields, "channel", req.Channel.ID)
+	}
+	if req.Message != nil && req.Message.Author != nil {
+		fields = append(fields, "user", req.Message.Author.ID)
+	}
+	if req.Command != nil {
+		fields = append(fields, "command", req.Command.GetPath())
+	}
+	if !req.started.IsZero() {
+		fields = append(fields, "latency", time.Since(req.started))
+	}
+	return append(fields, keyvals...)
+}
@@ -0,0 +1,323 @@
+package sugo
+
+import (
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maintenanceNamespace is the storage namespace maintenance mode state is kept in.
+const maintenanceNamespace = "sugo.maintenance"
+
+// defaultMaintenanceMessage is shown to the users during the maintenance if there is no other message.
+const defaultMaintenanceMessage = "bot is under maintenance, please try again later"
+
+// Maintenance describes maintenance mode of the bot or of the guild.
+type Maintenance struct {
+	// GuildID is the guild under maintenance, empty for the global maintenance.
+	GuildID string `json:"guild_id,omitempty"`
+	// Message is shown to the users, MaintenanceMessage is used if empty.
+	Message string `json:"message,omitempty"`
+	// ETA is the time maintenance is expected to end at. Zero value means unknown.
+	ETA       time.Time `json:"eta,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	StartedBy string    `json:"started_by,omitempty"`
+}
+
+// userMessage returns the message to be shown to the users.
+func (m *Maintenance) userMessage(sg *Instance) string {
+	message := m.Message
+	if message == "" {
+		message = sg.MaintenanceMessage
+	}
+	if message == "" {
+		message = defaultMaintenanceMessage
+	}
+	if !m.ETA.IsZero() {
+		if eta := time.Until(m.ETA).Round(time.Minute); eta > 0 {
+			message += ", expected to be over in " + eta.String()
+		}
+	}
+	return message
+}
+
+// maintenanceKey returns the storage key of the maintenance state of the guild (global if guild ID is empty).
+func maintenanceKey(guildID string) string {
+	if guildID == "" {
+		return "global"
+	}
+	return "guilds/" + guildID
+}
+
+// StartMaintenance puts the guild (or the whole bot if guild ID is empty) into maintenance mode.
+func (sg *Instance) StartMaintenance(m *Maintenance) error {
+	if m.StartedAt.IsZero() {
+		m.StartedAt = time.Now().UTC()
+	}
+	if err := sg.Store(maintenanceNamespace).SetJSON(maintenanceKey(m.GuildID), m, 0); err != nil {
+		return errors.Wrap(err, "unable to start maintenance")
+	}
+	sg.logger().Info("maintenance started", "guild", m.GuildID, "by", m.StartedBy)
+	if m.GuildID == "" && sg.State() == StateRunning {
+		sg.updateMaintenanceStatus()
+	}
+	return nil
+}
+
+// StopMaintenance takes the guild (or the whole bot if guild ID is empty) out of maintenance mode.
+func (sg *Instance) StopMaintenance(guildID string) error {
+	if err := sg.Store(maintenanceNamespace).Delete(maintenanceKey(guildID)); err != nil {
+		return errors.Wrap(err, "unable to stop maintenance")
+	}
+	sg.logger().Info("maintenance stopped", "guild", guildID)
+	if guildID == "" && sg.State() == StateRunning {
+		sg.updateMaintenanceStatus()
+	}
+	return nil
+}
+
+// GetMaintenance returns the maintenance of the guild (global if guild ID is empty) or nil if there is none.
+func (sg *Instance) GetMaintenance(guildID string) (*Maintenance, error) {
+	m := &Maintenance{}
+	ok, err := sg.Store(maintenanceNamespace).GetJSON(maintenanceKey(guildID), m)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get maintenance state")
+	}
+	if !ok {
+		return nil, nil
+	}
+	return m, nil
+}
+
+// activeMaintenance returns the maintenance that applies to the Request: global one or the one of the guild.
+func (sg *Instance) activeMaintenance(req *Request) (*Maintenance, error) {
+	m, err := sg.GetMaintenance("")
+	if err != nil || m != nil {
+		return m, err
+	}
+	if req.Channel.GuildID == "" {
+		return nil, nil
+	}
+	return sg.GetMaintenance(req.Channel.GuildID)
+}
+
+// checkMaintenance returns MaintenanceError if the Request falls under maintenance. Owners and members of
+// MaintenanceAllowRoles are not affected.
+func (sg *Instance) checkMaintenance(req *Request) error {
+	m, err := sg.activeMaintenance(req)
+	if err != nil || m == nil {
+		return err
+	}
+	if req.IsOwner() {
+		return nil
+	}
+	if len(sg.MaintenanceAllowRoles) > 0 && req.Channel.GuildID != "" {
+		roles, err := sg.memberRoles(req.Channel.GuildID, req.Message.Author.ID)
+		if err != nil {
+			return err
+		}
+		if sg.hasRole(req.Channel.GuildID, roles, sg.MaintenanceAllowRoles) {
+			return nil
+		}
+	}
+	return &MaintenanceError{Message: m.userMessage(sg), ETA: m.ETA}
+}
+
+// botStatus is the bot presence status.
+type botStatus struct {
+	idle int
+	game string
+}
+
+// SetStatus sets the bot presence status the same way discordgo Session.UpdateStatus does. The status is remembered:
+// it's kept aside during the global maintenance, restored after it and on reconnects.
+func (sg *Instance) SetStatus(idle int, game string) error {
+	sg.statusMu.Lock()
+	defer sg.statusMu.Unlock()
+	sg.status = &botStatus{idle: idle, game: game}
+	if sg.maintenanceStatus || sg.State() != StateRunning {
+		return nil
+	}
+	if err := sg.Session.UpdateStatus(idle, game); err != nil {
+		return errors.Wrap(err, "unable to update bot status")
+	}
+	return nil
+}
+
+// updateMaintenanceStatus reflects the global maintenance in the bot presence status. Presence is only touched while
+// the maintenance is on, status set with SetStatus (if any) is restored once it's over.
+func (sg *Instance) updateMaintenanceStatus() {
+	m, err := sg.GetMaintenance("")
+	if err != nil {
+		sg.HandleError(nil, err)
+		return
+	}
+
+	sg.statusMu.Lock()
+	defer sg.statusMu.Unlock()
+	status := botStatus{}
+	switch {
+	case m != nil:
+		status.game = "maintenance"
+	case sg.status != nil:
+		status = *sg.status
+	case !sg.maintenanceStatus:
+		// Nothing to show or restore.
+		return
+	}
+	if err = sg.Session.UpdateStatus(status.idle, status.game); err != nil {
+		sg.HandleError(nil, errors.Wrap(err, "unable to update bot status"))
+		return
+	}
+	sg.maintenanceStatus = m != nil
+}
+
+// trackMaintenanceStatus makes bot restore maintenance status every time it (re)connects to the gateway.
+func (sg *Instance) trackMaintenanceStatus() {
+	sg.Session.AddHandler(func(_ *discordgo.Session, _ *discordgo.Ready) {
+		sg.updateMaintenanceStatus()
+	})
+}
+
+// adminMaintenance serves the maintenance admin HTTP route. GET returns the maintenance state, POST starts the
+// maintenance and DELETE stops it. Optional "guild" query parameter limits it to the guild, POST also accepts
+// "message" and "eta" (duration) parameters.
+func (sg *Instance) adminMaintenance(w http.ResponseWriter, r *http.Request) {
+	guildID := r.URL.Query().Get("guild")
+	if guildID != "" && !isSnowflake(guildID) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid guild"})
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		m, err := sg.GetMaintenance(guildID)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"maintenance": m != nil, "details": m})
+	case http.MethodPost:
+		m := &Maintenance{GuildID: guildID, Message: r.URL.Query().Get("message"), StartedBy: "admin http endpoint"}
+		if raw := r.URL.Query().Get("eta"); raw != "" {
+			eta, err := time.ParseDuration(raw)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid eta"})
+				return
+			}
+			m.ETA = time.Now().UTC().Add(eta)
+		}
+		if err := sg.StartMaintenance(m); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"maintenance": true, "details": m})
+	case http.MethodDelete:
+		if err := sg.StopMaintenance(guildID); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"maintenance": false})
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+	}
+}
+
+// MaintenanceCommand makes the owner only command that toggles maintenance mode. The command is not added
+// automatically, use AddCommand to add it.
+func (sg *Instance) MaintenanceCommand() *Command {
+	// parseParams parses "[here] [eta] [message...]" parameters.
+	parseParams := func(req *Request) (guildID string, eta time.Duration, message string, err error) {
+		params := strings.Fields(req.Query)
+		if len(params) > 0 && params[0] == "here" {
+			// There is no guild in DMs, so it would be the global maintenance instead.
+			if req.Channel.GuildID == "" {
+				return "", 0, "", NewUserError("\"here\" can only be used in guilds")
+			}
+			guildID = req.Channel.GuildID
+			params = params[1:]
+		}
+		if len(params) > 0 {
+			if d, err := time.ParseDuration(params[0]); err == nil && d > 0 {
+				eta = d
+				params = params[1:]
+			}
+		}
+		return guildID, eta, strings.Join(params, " "), nil
+	}
+
+	// scopeName describes the maintenance scope.
+	scopeName := func(guildID string) string {
+		if guildID == "" {
+			return "globally"
+		}
+		return "in this guild"
+	}
+
+	return &Command{
+		Trigger:     "maintenance",
+		Description: "manages maintenance mode",
+		OwnerOnly:   true,
+		SubCommands: []*Command{
+			{
+				Trigger:     "on",
+				Description: "starts maintenance globally or (with \"here\") in this guild: maintenance on [here] [eta] [message]",
+				HasParams:   true,
+				Execute: func(req *Request) (*Response, error) {
+					guildID, eta, message, err := parseParams(req)
+					if err != nil {
+						return nil, err
+					}
+					m := &Maintenance{GuildID: guildID, Message: message, StartedBy: req.Message.Author.ID}
+					if eta > 0 {
+						m.ETA = time.Now().UTC().Add(eta)
+					}
+					if err := sg.StartMaintenance(m); err != nil {
+						return nil, err
+					}
+					return req.NewResponse(ResponseSuccess, "maintenance",
+						"maintenance started "+scopeName(guildID)+", users see:\n"+m.userMessage(sg)), nil
+				},
+			},
+			{
+				Trigger:     "off",
+				Description: "stops maintenance globally or (with \"here\") in this guild: maintenance off [here]",
+				HasParams:   true,
+				Execute: func(req *Request) (*Response, error) {
+					guildID, _, _, err := parseParams(req)
+					if err != nil {
+						return nil, err
+					}
+					if err := sg.StopMaintenance(guildID); err != nil {
+						return nil, err
+					}
+					return req.NewResponse(ResponseSuccess, "maintenance", "maintenance stopped "+scopeName(guildID)), nil
+				},
+			},
+			{
+				Trigger:     "status",
+				Description: "shows if maintenance is on",
+				Execute: func(req *Request) (*Response, error) {
+					scopes := []string{""}
+					if req.Channel.GuildID != "" {
+						scopes = append(scopes, req.Channel.GuildID)
+					}
+					var lines []string
+					for _, guildID := range scopes {
+						m, err := sg.GetMaintenance(guildID)
+						if err != nil {
+							return nil, err
+						}
+						state := "off"
+						if m != nil {
+							state = "on: " + m.userMessage(sg)
+						}
+						lines = append(lines, "**"+scopeName(guildID)+"** "+state)
+					}
+					return req.NewResponse(ResponseInfo, "maintenance", strings.Join(lines, "\n")), nil
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,351 @@
+package sugo
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultLatencyBuckets are the histogram buckets (in seconds) used for latencies.
+var defaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Metric is implemented by Counter and Histogram.
+type Metric interface {
+	// write writes the metric in Prometheus text format.
+	write(w *bufio.Writer)
+}
+
+// labelKey joins label values into the map key.
+func labelKey(values []string) string {
+	return strings.Join(values, "\x00")
+}
+
+// formatLabels formats label names and values as Prometheus labels set.
+func formatLabels(names []string, values []string, extra ...string) string {
+	var pairs []string
+	for i, name := range names {
+		var value string
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs = append(pairs, name+`="`+escapeLabelValue(value)+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escapeLabelValue(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// escapeLabelValue escapes label value according to Prometheus text format.
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// formatFloat formats the value according to Prometheus text format.
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Counter is a metric that can only go up. It's partitioned by labels.
+type Counter struct {
+	// name is the metric name.
+	name string
+	// help is the metric description.
+	help string
+	// labels contains label names.
+	labels []string
+
+	// mu guards values.
+	mu sync.Mutex
+	// values contains metric values by label values.
+	values map[string]float64
+	// labelValues contains the label values by key.
+	labelValues map[string][]string
+}
+
+// NewCounter creates Counter with the given name, description and label names.
+func NewCounter(name string, help string, labels ...string) *Counter {
+	return &Counter{
+		name:        name,
+		help:        help,
+		labels:      labels,
+		values:      map[string]float64{},
+		labelValues: map[string][]string{},
+	}
+}
+
+// Inc increments the counter for the given label values by one.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increments the counter for the given label values by v.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if c == nil {
+		return
+	}
+	key := labelKey(labelValues)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[key] += v
+	c.labelValues[key] = labelValues
+}
+
+// Value returns current counter value for the given label values.
+func (c *Counter) Value(labelValues ...string) float64 {
+	if c == nil {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[labelKey(labelValues)]
+}
+
+// write writes the metric in Prometheus text format.
+func (c *Counter) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	w.WriteString("# HELP " + c.name + " " + c.help + "\n")
+	w.WriteString("# TYPE " + c.name + " counter\n")
+	for _, key := range sortedKeys(c.labelValues) {
+		w.WriteString(c.name + formatLabels(c.labels, c.labelValues[key]) + " " + formatFloat(c.values[key]) + "\n")
+	}
+}
+
+// Histogram is a metric that counts observations in buckets. It's partitioned by labels.
+type Histogram struct {
+	// name is the metric name.
+	name string
+	// help is the metric description.
+	help string
+	// labels contains label names.
+	labels []string
+	// buckets contains upper bounds of the buckets in ascending order.
+	buckets []float64
+
+	// mu guards values.
+	mu sync.Mutex
+	// values contains metric values by label values.
+	values map[string]*histogramValue
+	// labelValues contains the label values by key.
+	labelValues map[string][]string
+}
+
+// histogramValue contains observations for a single set of label values.
+type histogramValue struct {
+	// counts contains observations count per bucket (non-cumulative).
+	counts []uint64
+	// count is the total observations count.
+	count uint64
+	// sum is the sum of all the observations.
+	sum float64
+}
+
+// NewHistogram creates Histogram with the given name, description, bucket upper bounds and label names.
+func NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &Histogram{
+		name:        name,
+		help:        help,
+		labels:      labels,
+		buckets:     buckets,
+		values:      map[string]*histogramValue{},
+		labelValues: map[string][]string{},
+	}
+}
+
+// Observe adds single observation for the given label values.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	if h == nil {
+		return
+	}
+	key := labelKey(labelValues)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	value, ok := h.values[key]
+	if !ok {
+		value = &histogramValue{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = value
+		h.labelValues[key] = labelValues
+	}
+	for i, bound := range h.buckets {
+		if v <= bound {
+			value.counts[i]++
+			break
+		}
+	}
+	value.count++
+	value.sum += v
+}
+
+// ObserveDuration adds single duration observation (in seconds) for the given label values.
+func (h *Histogram) ObserveDuration(d time.Duration, labelValues ...string) {
+	h.Observe(d.Seconds(), labelValues...)
+}
+
+// Count returns the amount of observations for the given label values.
+func (h *Histogram) Count(labelValues ...string) uint64 {
+	if h == nil {
+		return 0
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if value, ok := h.values[labelKey(labelValues)]; ok {
+		return value.count
+	}
+	return 0
+}
+
+// Sum returns the sum of observations for the given label values.
+func (h *Histogram) Sum(labelValues ...string) float64 {
+	if h == nil {
+		return 0
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if value, ok := h.values[labelKey(labelValues)]; ok {
+		return value.sum
+	}
+	return 0
+}
+
+// write writes the metric in Prometheus text format.
+func (h *Histogram) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	w.WriteString("# HELP " + h.name + " " + h.help + "\n")
+	w.WriteString("# TYPE " + h.name + " histogram\n")
+	for _, key := range sortedKeys(h.labelValues) {
+		value, labelValues := h.values[key], h.labelValues[key]
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += value.counts[i]
+			w.WriteString(h.name + "_bucket" + formatLabels(h.labels, labelValues, "le", formatFloat(bound)) + " " + strconv.FormatUint(cumulative, 10) + "\n")
+		}
+		w.WriteString(h.name + "_bucket" + formatLabels(h.labels, labelValues, "le", "+Inf") + " " + strconv.FormatUint(value.count, 10) + "\n")
+		w.WriteString(h.name + "_sum" + formatLabels(h.labels, labelValues) + " " + formatFloat(value.sum) + "\n")
+		w.WriteString(h.name + "_count" + formatLabels(h.labels, labelValues) + " " + strconv.FormatUint(value.count, 10) + "\n")
+	}
+}
+
+// sortedKeys returns map keys in sorted order for the output to be stable.
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Metrics contains all the bot metrics.
+type Metrics struct {
+	// MessagesSeen counts all the messages bot has received.
+	MessagesSeen *Counter
+	// MessagesTriggered counts messages bot was triggered by.
+	MessagesTriggered *Counter
+	// CommandsExecuted counts executed commands by command path.
+	CommandsExecuted *Counter
+	// CommandDuration tracks command execution latency (including command middlewares) by command path.
+	CommandDuration *Histogram
+	// Errors counts errors by type.
+	Errors *Counter
+	// PermissionDenials counts commands that matched but were denied due to the lack of permissions by command path.
+	PermissionDenials *Counter
+	// ResponsesSent counts responses sent by response type.
+	ResponsesSent *Counter
+	// RESTFailures counts failed discord REST calls by operation.
+	RESTFailures *Counter
+
+	// mu guards metrics.
+	mu sync.Mutex
+	// metrics contains all the registered metrics in order of registration.
+	metrics []Metric
+}
+
+// NewMetrics creates Metrics with all the bot metrics registered.
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		MessagesSeen:      NewCounter("sugo_messages_seen_total", "Messages received by the bot."),
+		MessagesTriggered: NewCounter("sugo_messages_triggered_total", "Messages the bot was triggered by."),
+		CommandsExecuted:  NewCounter("sugo_commands_executed_total", "Commands executed.", "command"),
+		CommandDuration:   NewHistogram("sugo_command_duration_seconds", "Command execution latency.", defaultLatencyBuckets, "command"),
+		Errors:            NewCounter("sugo_errors_total", "Errors by type.", "type"),
+		PermissionDenials: NewCounter("sugo_permission_denials_total", "Commands denied due to the lack of permissions.", "command"),
+		ResponsesSent:     NewCounter("sugo_responses_sent_total", "Responses sent.", "type"),
+		RESTFailures:      NewCounter("sugo_rest_failures_total", "Failed discord REST calls.", "operation"),
+	}
+	m.Register(m.MessagesSeen, m.MessagesTriggered, m.CommandsExecuted, m.CommandDuration, m.Errors,
+		m.PermissionDenials, m.ResponsesSent, m.RESTFailures)
+	return m
+}
+
+// Register registers additional metrics (made with NewCounter or NewHistogram) to be exposed along with the bot ones.
+func (m *Metrics) Register(metrics ...Metric) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.metrics = append(m.metrics, metrics...)
+}
+
+// WriteTo writes all the metrics in Prometheus text format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	metrics := append([]Metric(nil), m.metrics...)
+	m.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, metric := range metrics {
+		metric.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// ServeHTTP exposes the metrics in Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = m.WriteTo(w)
+}
+
+// countingWriter counts bytes written.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+// Write implements io.Writer interface.
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// metrics returns the Instance metrics. If metrics are not set up - empty Metrics are returned, recording into them
+// does nothing.
+func (sg *Instance) metrics() *Metrics {
+	if sg.Metrics == nil {
+		return &Metrics{}
+	}
+	return sg.Metrics
+}
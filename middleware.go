@@ -0,0 +1,154 @@
+package sugo
+
+import (
+	"strconv"
+	"sync/atomic"
+)
+
+// Handler processes the Request and returns the Response to be sent back (if any).
+type Handler func(req *Request) (*Response, error)
+
+// Middleware wraps the request processing. It may do something before and after calling next, replace the Response
+// returned by next or not call next at all to abort the Request processing.
+type Middleware func(req *Request, next Handler) (*Response, error)
+
+// RequestMiddleware is a legacy middleware that is run before the command is searched for. If it returns an error -
+// Request processing is aborted.
+type RequestMiddleware func(*Request) error
+
+// ResponseMiddleware is a legacy middleware that is run on the Response after the command is executed. If it returns
+// an error - Response is not sent.
+type ResponseMiddleware func(*Response) error
+
+// namedMiddleware is a Middleware along with it's name.
+type namedMiddleware struct {
+	name string
+	fn   Middleware
+}
+
+// middlewareChain is an ordered list of middlewares. First middleware in the chain is the outermost one.
+type middlewareChain []namedMiddleware
+
+// add adds middleware to the end of the chain. If middleware with the same name already exists - it gets replaced in
+// place, so the order is preserved.
+func (mc middlewareChain) add(name string, m Middleware) middlewareChain {
+	for i := range mc {
+		if mc[i].name == name {
+			mc[i].fn = m
+			return mc
+		}
+	}
+	return append(mc, namedMiddleware{name: name, fn: m})
+}
+
+// remove removes middleware with the given name from the chain.
+func (mc middlewareChain) remove(name string) (middlewareChain, bool) {
+	for i := range mc {
+		if mc[i].name == name {
+			return append(mc[:i:i], mc[i+1:]...), true
+		}
+	}
+	return mc, false
+}
+
+// wrap wraps the given handler into the chain middlewares.
+func (mc middlewareChain) wrap(h Handler) Handler {
+	for i := len(mc) - 1; i >= 0; i-- {
+		h = protect(bindMiddleware(mc[i].name, mc[i].fn, h))
+	}
+	return h
+}
+
+// bindMiddleware makes Handler out of the Middleware and the next Handler. Middleware is traced as a separate span.
+func bindMiddleware(name string, m Middleware, next Handler) Handler {
+	return func(req *Request) (resp *Response, err error) {
+		err = traceStage(req, "middleware "+name, func() (err error) {
+			resp, err = m(req, next)
+			return
+		})
+		return
+	}
+}
+
+// protect makes handler return *PanicError instead of panicking, so outer middlewares are able to handle it as a
+// regular error.
+func protect(h Handler) Handler {
+	return func(req *Request) (resp *Response, err error) {
+		defer recoverPanic(&err)
+		return h(req)
+	}
+}
+
+// Use adds global middleware that wraps every Request the bot is triggered by, including the ones that do not match
+// any command (req.Command is nil until next is called). Middlewares are run in order they were added in. If
+// middleware with the same name is already added - it gets replaced.
+func (sg *Instance) Use(name string, m Middleware) {
+	sg.middlewares = sg.middlewares.add(name, m)
+}
+
+// RemoveMiddleware removes global middleware by name. Returns false if there was no such middleware.
+func (sg *Instance) RemoveMiddleware(name string) (ok bool) {
+	sg.middlewares, ok = sg.middlewares.remove(name)
+	return
+}
+
+// AddRequestMiddleware adds request middleware.
+//
+// Deprecated: use Use instead.
+func (sg *Instance) AddRequestMiddleware(m RequestMiddleware) {
+	sg.Use("request#"+strconv.FormatInt(atomic.AddInt64(&sg.legacyMiddlewares, 1), 10), func(req *Request, next Handler) (*Response, error) {
+		if err := m(req); err != nil {
+			return nil, err
+		}
+		return next(req)
+	})
+}
+
+// AddResponseMiddleware adds response middleware.
+//
+// Deprecated: use Use instead.
+func (sg *Instance) AddResponseMiddleware(m ResponseMiddleware) {
+	sg.Use("response#"+strconv.FormatInt(atomic.AddInt64(&sg.legacyMiddlewares, 1), 10), func(req *Request, next Handler) (*Response, error) {
+		resp, err := next(req)
+		if err != nil {
+			return resp, err
+		}
+		if err = m(resp); err != nil {
+			return nil, err
+		}
+		return resp, nil
+	})
+}
+
+// Use adds middleware that wraps execution of the command and all of it's subcommands. Command middlewares are run
+// after the global ones, outer commands middlewares go first. If middleware with the same name is already added - it
+// gets replaced.
+func (c *Command) Use(name string, m Middleware) {
+	c.middlewares = c.middlewares.add(name, m)
+}
+
+// UseLocal adds middleware that wraps execution of the command itself, but not it's subcommands. Local middlewares
+// are run after all the other ones.
+func (c *Command) UseLocal(name string, m Middleware) {
+	c.localMiddlewares = c.localMiddlewares.add(name, m)
+}
+
+// RemoveMiddleware removes command middleware (either subtree or local one) by name. Returns false if there was no
+// such middleware.
+func (c *Command) RemoveMiddleware(name string) bool {
+	var removed, removedLocal bool
+	c.middlewares, removed = c.middlewares.remove(name)
+	c.localMiddlewares, removedLocal = c.localMiddlewares.remove(name)
+	return removed || removedLocal
+}
+
+// middlewareChain returns full chain of middlewares applicable to the command: middlewares of all parents from the
+// outermost one, then own middlewares and then local ones.
+func (c *Command) middlewareChain() middlewareChain {
+	var chain middlewareChain
+	if c.parent != nil {
+		chain = c.parent.middlewareChain()
+	}
+	chain = append(chain, c.middlewares...)
+	return append(chain, c.localMiddlewares...)
+}
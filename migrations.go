@@ -0,0 +1,181 @@
+package sugo
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// migrationsNamespace is the storage namespace current data versions are kept in.
+const migrationsNamespace = "sugo.migrations"
+
+// Migration converts the namespace data from the previous version to the Version.
+type Migration struct {
+	// Version is the data version after migration is applied. Versions start from 1.
+	Version int
+	// Description shortly describes what migration does.
+	Description string
+	// Up applies the migration to the namespace store.
+	Up func(store *Store) error
+}
+
+// PendingMigration is the migration that is not applied yet.
+type PendingMigration struct {
+	Namespace string
+	Migration
+}
+
+// AddMigrations registers migrations of the namespace data. Migrations are applied on startup before the startup
+// handlers in order of versions, every migration is applied only once.
+func (sg *Instance) AddMigrations(namespace string, migrations ...Migration) {
+	if sg.migrations == nil {
+		sg.migrations = map[string][]Migration{}
+	}
+	sg.migrations[namespace] = append(sg.migrations[namespace], migrations...)
+}
+
+// dataVersion returns the current data version of the namespace.
+func (sg *Instance) dataVersion(namespace string) (int, error) {
+	var version int
+	if _, err := sg.Store(migrationsNamespace).GetJSON(namespace, &version); err != nil {
+		return 0, errors.Wrap(err, "unable to get data version of "+namespace)
+	}
+	return version, nil
+}
+
+// PendingMigrations validates registered migrations and returns the ones that are not applied yet in order they are
+// to be applied in.
+func (sg *Instance) PendingMigrations() ([]PendingMigration, error) {
+	var namespaces []string
+	for namespace := range sg.migrations {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+
+	var pending []PendingMigration
+	for _, namespace := range namespaces {
+		migrations := append([]Migration(nil), sg.migrations[namespace]...)
+		sort.SliceStable(migrations, func(i, j int) bool {
+			return migrations[i].Version < migrations[j].Version
+		})
+
+		// Make sure migrations are valid.
+		for i, m := range migrations {
+			if m.Version <= 0 {
+				return nil, errors.New("invalid migration " + migrationName(namespace, m) + ": version must be positive")
+			}
+			if i > 0 && migrations[i-1].Version == m.Version {
+				return nil, errors.New("duplicate migration " + migrationName(namespace, m))
+			}
+			if m.Up == nil {
+				return nil, errors.New("invalid migration " + migrationName(namespace, m) + ": Up is not defined")
+			}
+		}
+
+		version, err := sg.dataVersion(namespace)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range migrations {
+			if m.Version > version {
+				pending = append(pending, PendingMigration{Namespace: namespace, Migration: m})
+			}
+		}
+	}
+	return pending, nil
+}
+
+// Migrate applies all the pending migrations. Snapshot of the whole storage is written into the MigrationsBackupDir
+// before any migration is applied. Migration failure stops the process, migrations applied before it stay applied.
+func (sg *Instance) Migrate() error {
+	pending, err := sg.PendingMigrations()
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	// Make a backup first.
+	backupPath, err := sg.backupStorage()
+	if err != nil {
+		return errors.Wrap(err, "unable to back storage up before migrations")
+	}
+	sg.logger().Info("storage backed up before migrations", "path", backupPath)
+
+	// Apply migrations one by one.
+	for _, m := range pending {
+		sg.logger().Info("applying migration", "namespace", m.Namespace, "version", m.Version, "description", m.Description)
+		if err = m.Up(sg.Store(m.Namespace)); err != nil {
+			return errors.Wrapf(err, "migration %s failed, storage backup is at %s", migrationName(m.Namespace, m.Migration), backupPath)
+		}
+		if err = sg.Store(migrationsNamespace).SetJSON(m.Namespace, m.Version, 0); err != nil {
+			return errors.Wrapf(err, "unable to save data version after migration %s", migrationName(m.Namespace, m.Migration))
+		}
+	}
+	return nil
+}
+
+// runMigrations applies pending migrations on startup or only reports them in dry-run mode.
+func (sg *Instance) runMigrations() error {
+	if !sg.MigrationsDryRun {
+		return sg.Migrate()
+	}
+
+	pending, err := sg.PendingMigrations()
+	if err != nil {
+		return err
+	}
+	for _, m := range pending {
+		sg.logger().Info("pending migration", "namespace", m.Namespace, "version", m.Version, "description", m.Description)
+	}
+	sg.logger().Info("migrations dry run finished", "pending", len(pending))
+	return nil
+}
+
+// backupStorage writes the whole storage content into the JSON file in MigrationsBackupDir and returns it's path.
+// Values are written by namespace and key along with their expiration time, so expiring values can be restored as
+// such.
+func (sg *Instance) backupStorage() (string, error) {
+	storage := sg.storage()
+	dump, err := dumpStorage(storage, "")
+	if err != nil {
+		return "", err
+	}
+	backup := map[string]map[string]storageEntry{}
+	for namespace, values := range dump {
+		backup[namespace] = map[string]storageEntry{}
+		for key, value := range values {
+			expiresAt, ok, err := storage.ExpiresAt(namespace, key)
+			if err != nil {
+				return "", errors.Wrap(err, "unable to get expiration time of "+namespace+"/"+key)
+			}
+			if ok {
+				backup[namespace][key] = storageEntry{Value: value, ExpiresAt: expiresAt}
+			}
+		}
+	}
+	b, err := json.Marshal(backup)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to marshal storage backup")
+	}
+
+	path := filepath.Join(sg.MigrationsBackupDir, "storage-backup-"+strconv.FormatInt(time.Now().Unix(), 10)+".json")
+	if err = ioutil.WriteFile(path, b, 0600); err != nil {
+		return "", errors.Wrap(err, "unable to write storage backup")
+	}
+	return path, nil
+}
+
+// migrationName returns human-readable migration name for the error messages.
+func migrationName(namespace string, m Migration) string {
+	name := namespace + "/" + strconv.Itoa(m.Version)
+	if m.Description != "" {
+		name += " (" + m.Description + ")"
+	}
+	return name
+}
@@ -1,26 +1,39 @@
 package sugo
 
 import (
-	"context"
 	"github.com/bwmarrin/discordgo"
 	"github.com/pkg/errors"
 	"strings"
+	"time"
 )
 
 // onMessageCreate is a lowest level handler for bot. All the Request building and command searching magic happen here.
 func (sg *Instance) onMessageCreate(m *discordgo.Message) {
 	var err error
 
+	sg.metrics().MessagesSeen.Inc()
+
 	// Ignore a message it's author is bot.
 	if m.Author.Bot {
+		sg.logger().Debug("message ignored: author is a bot", "message", m.ID, "user", m.Author.ID)
 		return
 	}
 
 	// Instantiate Request.
-	var req = &Request{}
+	var req = &Request{started: time.Now()}
+
+	// Make sure nothing that happens while processing the Request can bring the whole bot down.
+	defer func() {
+		if v := recover(); v != nil {
+			sg.HandleError(req, errors.Wrap(newPanicError(v), "message processing error"))
+		}
+	}()
 
-	// Create Request context.
-	req.Ctx = context.Background()
+	// Generate Request ID.
+	req.ID = newRequestID()
+
+	// Create Request context. It's derived from the bot context so it gets cancelled on bot shutdown.
+	req.Ctx = sg.Context()
 
 	// Put bot pointer into the appropriate Request var for later reference.
 	req.Sugo = sg
@@ -32,55 +45,184 @@ func (sg *Instance) onMessageCreate(m *discordgo.Message) {
 	req.Query = m.Content
 
 	// Get message channel and put it into the Request.
-	req.Channel, err = sg.Session.State.Channel(req.Message.ChannelID)
+	req.Channel, err = sg.channel(req.Message.ChannelID)
 	if err != nil {
 		sg.HandleError(req, errors.Wrap(err, "unable to retrieve discord channel"))
+		return
+	}
+
+	// Ignore messages of the blocked users and guilds. Guild is taken from the channel, as messages do not always
+	// have it set.
+	if blocked, err := sg.isMessageBlocked(m, req.Channel.GuildID); err != nil {
+		sg.HandleError(req, errors.Wrap(err, "unable to check the blocklist"))
+		return
+	} else if blocked {
+		sg.logger().Debug("message ignored: blocklisted", req.logFields("message", m.ID)...)
+		return
 	}
 
 	// Make sure bot is triggered by the Request.
+	triggerStarted := time.Now()
 	if !sg.isTriggered(req) {
+		sg.logger().Debug("message ignored: bot is not triggered", req.logFields("message", m.ID)...)
 		return
 	}
 
-	// Apply request middlewares if any.
-	for _, m := range sg.requestMiddlewares {
-		if err = m(req); err != nil {
-			sg.HandleError(req, err)
+	// Start Request tracing. Requests bot is not triggered by are not traced, so the trigger stage is recorded once
+	// it's known the Request is to be traced.
+	rootSpan := sg.startRootSpan(req)
+	recordStage(req, "trigger", triggerStarted, nil)
+	sg.metrics().MessagesTriggered.Inc()
+	sg.logger().Debug("bot triggered", req.logFields("message", m.ID, "query", req.Query)...)
+
+	// The root span reflects the Request processing error, errors of the response delivery are recorded by the send
+	// stage span.
+	var reqErr error
+	defer func() {
+		if req.Command != nil {
+			rootSpan.SetAttribute("command", req.Command.GetPath())
+		}
+		rootSpan.End(reqErr)
+	}()
+
+	// Process the Request with all the global middlewares applied.
+	resp, reqErr := sg.middlewares.wrap(protect(sg.handleRequest))(req)
+	if reqErr != nil {
+		// If it was a panic - remember it for the command.
+		if _, ok := errors.Cause(reqErr).(*PanicError); ok {
+			sg.registerPanic(req.Command)
+		}
+
+		sg.logger().Debug("request failed", req.logFields("error", reqErr)...)
+
+		// Replace the response with the error one.
+		var respErr ResponseError
+		respErr, resp = sg.renderError(req, reqErr)
+
+		// Internal errors are not something user can fix, so they have to be handled (and counted) by the error
+		// handler as well.
+		if _, ok := respErr.(*InternalError); ok {
+			sg.HandleError(req, errors.Wrap(reqErr, "request processing error"))
+		} else {
+			sg.metrics().Errors.Inc(errorType(respErr))
+		}
+	}
+
+	// Send the response if any.
+	if resp != nil {
+		if sendErr := traceStage(req, "send", func() (err error) {
+			_, err = resp.deliver()
+			return
+		}); sendErr != nil {
+			sg.HandleError(req, errors.Wrap(sendErr, "response processing error"))
+		} else {
+			sg.logger().Debug("response sent", req.logFields("response_type", string(resp.Type))...)
 		}
 	}
 
+	sg.logger().Debug("request processed", req.logFields()...)
+}
+
+// handleRequest is the innermost global Handler. It searches for the command and executes it with all the command
+// middlewares applied.
+func (sg *Instance) handleRequest(req *Request) (*Response, error) {
+	var err error
+
 	// Search for applicable command.
-	req.Command, err = sg.FindCommand(req, req.Query)
-	if err != nil {
-		sg.HandleError(req, errors.Wrap(err, "unable to search commands"))
+	err = traceStage(req, "find_command", func() (err error) {
+		req.Command, err = sg.FindCommand(req, req.Query)
+		return
+	})
+	denial, denied := err.(*commandDenial)
+	if err != nil && !denied {
+		return nil, errors.Wrap(err, "unable to search commands")
 	}
 
-	// If we have found applicable command:
-	if req.Command != nil {
-		// Remove command Trigger from message string.
-		req.Query = strings.TrimSpace(strings.TrimPrefix(req.Query, req.Command.GetPath()))
+	// Commands hidden from the user are denied silently, whatever else is going on.
+	if denied && !denial.Explainable {
+		return sg.handleDenial(req, denial)
+	}
 
-		// And execute command.
-		var resp *Response
-		if resp, err = req.Command.execute(sg, req); err != nil {
-			sg.HandleError(req, errors.Wrap(err, "command execution error"))
-		}
+	// Command not found, we do nothing.
+	if req.Command == nil && !denied {
+		sg.logger().Debug("command not found", req.logFields("query", req.Query)...)
+		return nil, nil
+	}
 
-		// Apply response middlewares.
-		for _, m := range sg.responseMiddlewares {
-			if err = m(resp); err != nil {
-				sg.HandleError(req, err)
-			}
+	// Commands are not available during the maintenance. Maintenance goes before the access denials, so users get
+	// the maintenance warning whether they are allowed to use the command or not.
+	if err = sg.checkMaintenance(req); err != nil {
+		if denied {
+			req.Command = denial.Command
 		}
+		req.Query = strings.TrimSpace(strings.TrimPrefix(req.Query, req.Command.GetPath()))
+		sg.audit(req, 0, err)
+		sg.recordUsage(req, 0, err)
+		return nil, errors.Wrap(err, "maintenance check failed")
+	}
 
-		// Process response.
-		if resp != nil {
-			if _, err = resp.Send(); err != nil {
-				sg.HandleError(req, errors.Wrap(err, "response processing error"))
-			}
-		}
+	// Commands that can not be used are audited along with the reason.
+	if denied {
+		return sg.handleDenial(req, denial)
 	}
+	sg.logger().Debug("command found", req.logFields()...)
+
+	// Remove command Trigger from message string.
+	req.Query = strings.TrimSpace(strings.TrimPrefix(req.Query, req.Command.GetPath()))
+
+	// And execute command.
+	started := time.Now()
+	resp, err := req.Command.middlewareChain().wrap(protect(sg.executeCommand))(req)
+	sg.metrics().CommandsExecuted.Inc(req.Command.GetPath())
+	sg.metrics().CommandDuration.ObserveDuration(time.Since(started), req.Command.GetPath())
+	sg.audit(req, time.Since(started), err)
+	sg.recordUsage(req, time.Since(started), err)
+	sg.logger().Debug("command executed", req.logFields("error", err)...)
+	return resp, err
+}
 
-	// Command not found, we do nothing.
-	return
+// handleDenial audits the command user can not use and explains the reason if it's appropriate. Permission denials
+// are only explained if bot is to ExplainDenials.
+func (sg *Instance) handleDenial(req *Request, denial *commandDenial) (*Response, error) {
+	req.Command = denial.Command
+	req.Query = strings.TrimSpace(strings.TrimPrefix(req.Query, req.Command.GetPath()))
+	sg.audit(req, 0, denial.Reason)
+	sg.logger().Debug("command denied", req.logFields("reason", denial.Reason)...)
+
+	if _, ok := denial.Reason.(*PermissionError); !denial.Explainable || ok && !sg.ExplainDenials {
+		return nil, nil
+	}
+	return nil, denial.Reason
+}
+
+// executeCommand is the innermost command Handler.
+func (sg *Instance) executeCommand(req *Request) (resp *Response, err error) {
+	// Make sure bot is able to execute the command at all.
+	if err = sg.checkBotPermissions(req); err != nil {
+		return nil, errors.Wrap(err, "bot permissions check failed")
+	}
+
+	// Make sure command policies allow the execution.
+	if err = sg.checkPolicies(req); err != nil {
+		return nil, errors.Wrap(err, "command policies check failed")
+	}
+
+	// Failed (or panicked) executions do not count.
+	defer func() {
+		if r := recover(); r != nil {
+			sg.releasePolicies(req, req.policies())
+			panic(r)
+		}
+		if err != nil {
+			sg.releasePolicies(req, req.policies())
+		}
+	}()
+
+	if err = traceStage(req, "execute", func() (err error) {
+		resp, err = req.Command.execute(sg, req)
+		return
+	}); err != nil {
+		return resp, errors.Wrap(err, "command execution error")
+	}
+	return resp, nil
 }
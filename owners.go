@@ -0,0 +1,83 @@
+package sugo
+
+import (
+	"encoding/json"
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+)
+
+// OwnerBypass is a set of checks bot owners are exempt from.
+type OwnerBypass int
+
+const (
+	// OwnerBypassPermissions makes owners skip PermissionsRequired, RolesRequired and guild ACL checks.
+	OwnerBypassPermissions OwnerBypass = 1 << iota
+	// OwnerBypassCooldowns makes owners skip cooldowns, quotas and other throttling.
+	OwnerBypassCooldowns
+)
+
+// teamMembershipAccepted is the team membership state of the members that accepted the team invite.
+const teamMembershipAccepted = 2
+
+// applicationOwners is the part of the current application details the owners are taken from.
+type applicationOwners struct {
+	Owner *discordgo.User `json:"owner"`
+	Team  *struct {
+		OwnerUserID string `json:"owner_user_id"`
+		Members     []struct {
+			MembershipState int             `json:"membership_state"`
+			User            *discordgo.User `json:"user"`
+		} `json:"members"`
+	} `json:"team"`
+}
+
+// loadApplicationOwners adds the bot application owner or, if application belongs to a team, all of the team
+// members to the bot Owners.
+func (sg *Instance) loadApplicationOwners() error {
+	endpoint := discordgo.EndpointApplications + "/@me"
+	body, err := sg.Session.RequestWithBucketID("GET", endpoint, nil, endpoint)
+	if err != nil {
+		return errors.Wrap(err, "unable to get application details")
+	}
+
+	var app applicationOwners
+	if err = json.Unmarshal(body, &app); err != nil {
+		return errors.Wrap(err, "unable to decode application details")
+	}
+
+	if app.Team != nil {
+		sg.Owners = appendUnique(sg.Owners, app.Team.OwnerUserID)
+		for _, member := range app.Team.Members {
+			if member.User != nil && member.MembershipState == teamMembershipAccepted {
+				sg.Owners = appendUnique(sg.Owners, member.User.ID)
+			}
+		}
+	} else if app.Owner != nil {
+		sg.Owners = appendUnique(sg.Owners, app.Owner.ID)
+	}
+
+	sg.logger().Info("application owners loaded", "owners", len(sg.Owners))
+	return nil
+}
+
+// IsOwner returns true if Request author is one of the bot owners.
+func (req *Request) IsOwner() bool {
+	return req.Sugo.IsOwner(req.Message.Author.ID)
+}
+
+// Bypasses returns true if Request author is the bot owner and owners are configured to bypass the given checks.
+// Middlewares implementing cooldowns or other throttling should let the Request through if it
+// Bypasses(OwnerBypassCooldowns).
+func (req *Request) Bypasses(bypass OwnerBypass) bool {
+	return req.Sugo.OwnerBypass&bypass != 0 && req.IsOwner()
+}
+
+// isOwnerOnly returns true if the command or any of it's parents is OwnerOnly.
+func (c *Command) isOwnerOnly() bool {
+	for cmd := c; cmd != nil; cmd = cmd.parent {
+		if cmd.OwnerOnly {
+			return true
+		}
+	}
+	return false
+}
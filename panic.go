@@ -0,0 +1,69 @@
+package sugo
+
+import (
+	"fmt"
+	"runtime/debug"
+	"time"
+)
+
+// PanicError is the error command or middleware panic is converted to.
+type PanicError struct {
+	// Value is the value panic was called with.
+	Value interface{}
+	// Stack is the stack trace of the goroutine at the moment of panic.
+	Stack []byte
+}
+
+// Error implements error interface.
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// newPanicError makes *PanicError out of the recovered value. Must be called from the deferred function for the stack
+// to be captured properly.
+func newPanicError(v interface{}) *PanicError {
+	return &PanicError{
+		Value: v,
+		Stack: debug.Stack(),
+	}
+}
+
+// recoverPanic converts panic (if any) to *PanicError and stores it into err. Must be deferred directly.
+func recoverPanic(err *error) {
+	if v := recover(); v != nil {
+		*err = newPanicError(v)
+	}
+}
+
+// registerPanic remembers the command panic and disables the command if it panics too often.
+func (sg *Instance) registerPanic(cmd *Command) {
+	// If auto-disabling is not configured - there is nothing to do.
+	if cmd == nil || sg.PanicLimit <= 0 {
+		return
+	}
+
+	sg.panicsMu.Lock()
+	defer sg.panicsMu.Unlock()
+
+	if sg.panics == nil {
+		sg.panics = map[*Command][]time.Time{}
+	}
+
+	// Forget panics that happened outside of the window.
+	now := time.Now()
+	var recent []time.Time
+	for _, t := range sg.panics[cmd] {
+		if sg.PanicWindow <= 0 || now.Sub(t) < sg.PanicWindow {
+			recent = append(recent, t)
+		}
+	}
+	recent = append(recent, now)
+	sg.panics[cmd] = recent
+
+	// If command panicked too many times - disable it.
+	if len(recent) >= sg.PanicLimit {
+		cmd.Disable()
+		delete(sg.panics, cmd)
+		sg.HandleError(nil, fmt.Errorf("command disabled after %d panics: %s", len(recent), cmd.GetPath()))
+	}
+}
@@ -0,0 +1,260 @@
+package sugo
+
+import (
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+	"sync"
+	"time"
+)
+
+// permissionCacheTTL is the time resolved permissions are cached for. Cache entries are also invalidated on the
+// relevant discord events, TTL only limits the damage of the events missed.
+const permissionCacheTTL = 10 * time.Minute
+
+// permissionCacheKey identifies the cached permissions.
+type permissionCacheKey struct {
+	UserID    string
+	ChannelID string
+}
+
+// permissionCacheEntry contains the cached permissions.
+type permissionCacheEntry struct {
+	GuildID   string
+	Perms     int
+	ExpiresAt time.Time
+}
+
+// permissionCache caches the resolved permissions per (user, channel). Zero value is ready to use.
+type permissionCache struct {
+	mu      sync.Mutex
+	entries map[permissionCacheKey]permissionCacheEntry
+	// purgeAt is the time expired entries are to be removed at.
+	purgeAt time.Time
+}
+
+// get returns the cached permissions if there are any.
+func (pc *permissionCache) get(userID string, channelID string) (int, bool) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	entry, ok := pc.entries[permissionCacheKey{userID, channelID}]
+	if !ok || time.Now().After(entry.ExpiresAt) {
+		return 0, false
+	}
+	return entry.Perms, true
+}
+
+// set caches the permissions. Expired entries are removed once per TTL, so the cache does not grow forever.
+func (pc *permissionCache) set(userID string, channelID string, guildID string, perms int) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	now := time.Now()
+	if pc.entries == nil {
+		pc.entries = map[permissionCacheKey]permissionCacheEntry{}
+	}
+	if now.After(pc.purgeAt) {
+		for key, entry := range pc.entries {
+			if now.After(entry.ExpiresAt) {
+				delete(pc.entries, key)
+			}
+		}
+		pc.purgeAt = now.Add(permissionCacheTTL)
+	}
+	pc.entries[permissionCacheKey{userID, channelID}] = permissionCacheEntry{
+		GuildID:   guildID,
+		Perms:     perms,
+		ExpiresAt: now.Add(permissionCacheTTL),
+	}
+}
+
+// invalidate removes the cache entries the given function returns true for.
+func (pc *permissionCache) invalidate(fn func(key permissionCacheKey, entry permissionCacheEntry) bool) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	for key, entry := range pc.entries {
+		if fn(key, entry) {
+			delete(pc.entries, key)
+		}
+	}
+}
+
+// invalidateGuild removes all the cache entries of the guild.
+func (pc *permissionCache) invalidateGuild(guildID string) {
+	pc.invalidate(func(_ permissionCacheKey, entry permissionCacheEntry) bool {
+		return entry.GuildID == guildID
+	})
+}
+
+// invalidateMember removes all the cache entries of the user in the guild.
+func (pc *permissionCache) invalidateMember(guildID string, userID string) {
+	pc.invalidate(func(key permissionCacheKey, entry permissionCacheEntry) bool {
+		return entry.GuildID == guildID && key.UserID == userID
+	})
+}
+
+// invalidateChannel removes all the cache entries of the channel.
+func (pc *permissionCache) invalidateChannel(channelID string) {
+	pc.invalidate(func(key permissionCacheKey, _ permissionCacheEntry) bool {
+		return key.ChannelID == channelID
+	})
+}
+
+// trackPermissionChanges makes bot drop the cached permissions whenever roles, members or channels change.
+func (sg *Instance) trackPermissionChanges() {
+	sg.Session.AddHandler(func(_ *discordgo.Session, e *discordgo.GuildUpdate) {
+		sg.permissions.invalidateGuild(e.ID)
+	})
+	sg.Session.AddHandler(func(_ *discordgo.Session, e *discordgo.GuildDelete) {
+		sg.permissions.invalidateGuild(e.ID)
+	})
+	sg.Session.AddHandler(func(_ *discordgo.Session, e *discordgo.GuildRoleCreate) {
+		sg.permissions.invalidateGuild(e.GuildID)
+	})
+	sg.Session.AddHandler(func(_ *discordgo.Session, e *discordgo.GuildRoleUpdate) {
+		sg.permissions.invalidateGuild(e.GuildID)
+	})
+	sg.Session.AddHandler(func(_ *discordgo.Session, e *discordgo.GuildRoleDelete) {
+		sg.permissions.invalidateGuild(e.GuildID)
+	})
+	sg.Session.AddHandler(func(_ *discordgo.Session, e *discordgo.GuildMemberUpdate) {
+		if e.User != nil {
+			sg.permissions.invalidateMember(e.GuildID, e.User.ID)
+		}
+	})
+	sg.Session.AddHandler(func(_ *discordgo.Session, e *discordgo.GuildMemberRemove) {
+		if e.User != nil {
+			sg.permissions.invalidateMember(e.GuildID, e.User.ID)
+		}
+	})
+	sg.Session.AddHandler(func(_ *discordgo.Session, e *discordgo.ChannelUpdate) {
+		sg.permissions.invalidateChannel(e.ID)
+	})
+	sg.Session.AddHandler(func(_ *discordgo.Session, e *discordgo.ChannelDelete) {
+		sg.permissions.invalidateChannel(e.ID)
+	})
+}
+
+// channel returns the channel from the state or, if it's not there, requests it from discord.
+func (sg *Instance) channel(channelID string) (*discordgo.Channel, error) {
+	if channel, err := sg.Session.State.Channel(channelID); err == nil {
+		return channel, nil
+	}
+	channel, err := sg.Session.Channel(channelID)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get channel")
+	}
+	return channel, nil
+}
+
+// guild returns the guild from the state or, if it's not there, requests it from discord.
+func (sg *Instance) guild(guildID string) (*discordgo.Guild, error) {
+	if guild, err := sg.Session.State.Guild(guildID); err == nil {
+		return guild, nil
+	}
+	guild, err := sg.Session.Guild(guildID)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get guild")
+	}
+	return guild, nil
+}
+
+// guildMember returns the guild member from the state or, if it's not there, requests it from discord.
+func (sg *Instance) guildMember(guildID string, userID string) (*discordgo.Member, error) {
+	if member, err := sg.Session.State.Member(guildID, userID); err == nil {
+		return member, nil
+	}
+	member, err := sg.Session.GuildMember(guildID, userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get guild member")
+	}
+	return member, nil
+}
+
+// ChannelPermissions returns the permissions user has in the channel. Guild, member and channel details missing from
+// the state are requested from discord, results are cached until the relevant roles, members or channels change.
+// Users have all the text permissions in direct messages.
+func (sg *Instance) ChannelPermissions(userID string, channelID string) (int, error) {
+	if perms, ok := sg.permissions.get(userID, channelID); ok {
+		return perms, nil
+	}
+
+	channel, err := sg.channel(channelID)
+	if err != nil {
+		return 0, err
+	}
+
+	// Direct messages have no permissions to speak of.
+	if channel.GuildID == "" {
+		return discordgo.PermissionAllText, nil
+	}
+
+	guild, err := sg.guild(channel.GuildID)
+	if err != nil {
+		return 0, err
+	}
+
+	var perms int
+	if userID == guild.OwnerID {
+		// Guild owner can do anything.
+		perms = discordgo.PermissionAll
+	} else {
+		member, err := sg.guildMember(guild.ID, userID)
+		if err != nil {
+			return 0, err
+		}
+		perms = computePermissions(guild, channel, userID, member.Roles)
+	}
+
+	sg.permissions.set(userID, channelID, guild.ID, perms)
+	return perms, nil
+}
+
+// computePermissions computes the permissions of the guild member with the given roles in the channel.
+func computePermissions(guild *discordgo.Guild, channel *discordgo.Channel, userID string, memberRoles []string) int {
+	// Base permissions are the ones of @everyone role (it has the same ID as the guild) and all the member roles.
+	var perms int
+	for _, role := range guild.Roles {
+		if role.ID == guild.ID || containsString(memberRoles, role.ID) {
+			perms |= role.Permissions
+		}
+	}
+
+	// Administrators ignore channel overwrites.
+	if perms&discordgo.PermissionAdministrator != 0 {
+		return discordgo.PermissionAll
+	}
+
+	// Apply @everyone overwrite first, then role overwrites combined and member overwrite last.
+	var roleAllow, roleDeny int
+	var memberOverwrite *discordgo.PermissionOverwrite
+	for _, overwrite := range channel.PermissionOverwrites {
+		switch {
+		case overwrite.Type == "role" && overwrite.ID == guild.ID:
+			perms &^= overwrite.Deny
+			perms |= overwrite.Allow
+		case overwrite.Type == "role" && containsString(memberRoles, overwrite.ID):
+			roleDeny |= overwrite.Deny
+			roleAllow |= overwrite.Allow
+		case overwrite.Type == "member" && overwrite.ID == userID:
+			memberOverwrite = overwrite
+		}
+	}
+	perms &^= roleDeny
+	perms |= roleAllow
+	if memberOverwrite != nil {
+		perms &^= memberOverwrite.Deny
+		perms |= memberOverwrite.Allow
+	}
+
+	// Users that can not see the channel can not do anything else there.
+	if perms&discordgo.PermissionReadMessages == 0 {
+		return 0
+	}
+
+	// Users that can not send messages can not do anything messages related either.
+	if perms&discordgo.PermissionSendMessages == 0 {
+		perms &^= discordgo.PermissionSendTTSMessages | discordgo.PermissionMentionEveryone |
+			discordgo.PermissionEmbedLinks | discordgo.PermissionAttachFiles
+	}
+
+	return perms
+}
@@ -0,0 +1,234 @@
+package sugo
+
+import (
+	"github.com/bwmarrin/discordgo"
+	"testing"
+)
+
+// testGuild makes the guild with the owner, the member that has "mods" role and the channel. @everyone can read and
+// send messages, "mods" can manage them.
+func testGuild() *discordgo.Guild {
+	return &discordgo.Guild{
+		ID:      "guild",
+		OwnerID: "owner",
+		Roles: []*discordgo.Role{
+			{ID: "guild", Name: "@everyone", Permissions: discordgo.PermissionReadMessages | discordgo.PermissionSendMessages},
+			{ID: "mods", Name: "Moderators", Permissions: discordgo.PermissionManageMessages},
+		},
+		Channels: []*discordgo.Channel{{ID: "channel", GuildID: "guild", Type: discordgo.ChannelTypeGuildText}},
+		Members: []*discordgo.Member{
+			{GuildID: "guild", User: &discordgo.User{ID: "owner"}},
+			{GuildID: "guild", User: &discordgo.User{ID: "user"}, Roles: []string{"mods"}},
+		},
+	}
+}
+
+// newStateInstance makes the bot which state contains the given guild, so nothing is requested from discord.
+func newStateInstance(t *testing.T, guild *discordgo.Guild) *Instance {
+	t.Helper()
+	sg := New()
+	sg.Session = &discordgo.Session{State: discordgo.NewState()}
+	if err := sg.Session.State.GuildAdd(guild); err != nil {
+		t.Fatal(err)
+	}
+	return sg
+}
+
+func TestComputePermissions(t *testing.T) {
+	const (
+		read   = discordgo.PermissionReadMessages
+		send   = discordgo.PermissionSendMessages
+		embed  = discordgo.PermissionEmbedLinks
+		attach = discordgo.PermissionAttachFiles
+		manage = discordgo.PermissionManageMessages
+	)
+	overwrite := func(kind string, id string, allow int, deny int) *discordgo.PermissionOverwrite {
+		return &discordgo.PermissionOverwrite{ID: id, Type: kind, Allow: allow, Deny: deny}
+	}
+
+	tests := []struct {
+		name        string
+		everyone    int
+		roleA       int
+		roleB       int
+		overwrites  []*discordgo.PermissionOverwrite
+		memberRoles []string
+		want        int
+	}{
+		{
+			name:     "everyone role only",
+			everyone: read | send,
+			want:     read | send,
+		},
+		{
+			name:        "member roles are combined with everyone",
+			everyone:    read | send,
+			roleA:       embed,
+			roleB:       manage,
+			memberRoles: []string{"a"},
+			want:        read | send | embed,
+		},
+		{
+			name:        "administrator role ignores overwrites",
+			everyone:    read | send,
+			roleA:       discordgo.PermissionAdministrator,
+			overwrites:  []*discordgo.PermissionOverwrite{overwrite("role", "guild", 0, read)},
+			memberRoles: []string{"a"},
+			want:        discordgo.PermissionAll,
+		},
+		{
+			name:     "administrator everyone ignores overwrites",
+			everyone: discordgo.PermissionAdministrator,
+			overwrites: []*discordgo.PermissionOverwrite{
+				overwrite("member", "user", 0, read),
+			},
+			want: discordgo.PermissionAll,
+		},
+		{
+			name:       "everyone overwrite",
+			everyone:   read | send,
+			overwrites: []*discordgo.PermissionOverwrite{overwrite("role", "guild", manage, send)},
+			want:       read | manage,
+		},
+		{
+			name:     "role overwrites are combined and allow wins",
+			everyone: read | send,
+			overwrites: []*discordgo.PermissionOverwrite{
+				overwrite("role", "a", 0, embed),
+				overwrite("role", "b", embed, 0),
+			},
+			memberRoles: []string{"a", "b"},
+			want:        read | send | embed,
+		},
+		{
+			name:     "role overwrite wins over everyone overwrite",
+			everyone: read | send,
+			overwrites: []*discordgo.PermissionOverwrite{
+				overwrite("role", "a", send, 0),
+				overwrite("role", "guild", 0, send),
+			},
+			memberRoles: []string{"a"},
+			want:        read | send,
+		},
+		{
+			name:     "member overwrite wins over role overwrite",
+			everyone: read | send,
+			overwrites: []*discordgo.PermissionOverwrite{
+				overwrite("member", "user", 0, manage),
+				overwrite("role", "a", manage, 0),
+			},
+			memberRoles: []string{"a"},
+			want:        read | send,
+		},
+		{
+			name:     "overwrites of other roles and members are ignored",
+			everyone: read | send,
+			overwrites: []*discordgo.PermissionOverwrite{
+				overwrite("role", "b", 0, read),
+				overwrite("member", "other", 0, read),
+			},
+			memberRoles: []string{"a"},
+			want:        read | send,
+		},
+		{
+			name:       "no permissions without read",
+			everyone:   read | send | embed,
+			overwrites: []*discordgo.PermissionOverwrite{overwrite("role", "guild", 0, read)},
+			want:       0,
+		},
+		{
+			name:     "no message permissions without send",
+			everyone: read | embed | attach | manage,
+			want:     read | manage,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			guild := &discordgo.Guild{ID: "guild", Roles: []*discordgo.Role{
+				{ID: "guild", Permissions: tt.everyone},
+				{ID: "a", Permissions: tt.roleA},
+				{ID: "b", Permissions: tt.roleB},
+			}}
+			channel := &discordgo.Channel{ID: "channel", GuildID: "guild", PermissionOverwrites: tt.overwrites}
+			if got := computePermissions(guild, channel, "user", tt.memberRoles); got != tt.want {
+				t.Errorf("computePermissions() = %b, want %b", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChannelPermissions(t *testing.T) {
+	guild := testGuild()
+	// Nobody but the guild owner can see the channel.
+	guild.Channels[0].PermissionOverwrites = []*discordgo.PermissionOverwrite{
+		{ID: "guild", Type: "role", Deny: discordgo.PermissionReadMessages},
+	}
+	sg := newStateInstance(t, guild)
+	if err := sg.Session.State.ChannelAdd(&discordgo.Channel{ID: "dm", Type: discordgo.ChannelTypeDM}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		userID    string
+		channelID string
+		want      int
+	}{
+		{"guild owner", "owner", "channel", discordgo.PermissionAll},
+		{"guild member", "user", "channel", 0},
+		{"direct messages", "user", "dm", discordgo.PermissionAllText},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sg.ChannelPermissions(tt.userID, tt.channelID)
+			if err != nil {
+				t.Fatalf("ChannelPermissions() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("ChannelPermissions() = %b, want %b", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChannelPermissionsCacheInvalidation(t *testing.T) {
+	tests := []struct {
+		name       string
+		invalidate func(pc *permissionCache)
+		wantFresh  bool
+	}{
+		{"not invalidated", func(pc *permissionCache) {}, false},
+		{"guild", func(pc *permissionCache) { pc.invalidateGuild("guild") }, true},
+		{"other guild", func(pc *permissionCache) { pc.invalidateGuild("other") }, false},
+		{"member", func(pc *permissionCache) { pc.invalidateMember("guild", "user") }, true},
+		{"other member", func(pc *permissionCache) { pc.invalidateMember("guild", "owner") }, false},
+		{"channel", func(pc *permissionCache) { pc.invalidateChannel("channel") }, true},
+		{"other channel", func(pc *permissionCache) { pc.invalidateChannel("other") }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			guild := testGuild()
+			sg := newStateInstance(t, guild)
+			before, err := sg.ChannelPermissions("user", "channel")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Member loses "mods" permissions.
+			guild.Roles[1].Permissions = 0
+			tt.invalidate(&sg.permissions)
+
+			after, err := sg.ChannelPermissions("user", "channel")
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := before
+			if tt.wantFresh {
+				want = before &^ discordgo.PermissionManageMessages
+			}
+			if after != want {
+				t.Errorf("ChannelPermissions() = %b, want %b", after, want)
+			}
+		})
+	}
+}
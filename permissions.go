@@ -0,0 +1,111 @@
+package sugo
+
+import (
+	"github.com/bwmarrin/discordgo"
+	"strings"
+)
+
+// permissionNames contains human-readable names of all the discord permissions in the order they are listed in.
+var permissionNames = []struct {
+	Permission int
+	Name       string
+}{
+	{discordgo.PermissionCreateInstantInvite, "Create Invite"},
+	{discordgo.PermissionKickMembers, "Kick Members"},
+	{discordgo.PermissionBanMembers, "Ban Members"},
+	{discordgo.PermissionAdministrator, "Administrator"},
+	{discordgo.PermissionManageChannels, "Manage Channels"},
+	{discordgo.PermissionManageServer, "Manage Server"},
+	{discordgo.PermissionAddReactions, "Add Reactions"},
+	{discordgo.PermissionViewAuditLogs, "View Audit Log"},
+	{discordgo.PermissionReadMessages, "Read Messages"},
+	{discordgo.PermissionSendMessages, "Send Messages"},
+	{discordgo.PermissionSendTTSMessages, "Send TTS Messages"},
+	{discordgo.PermissionManageMessages, "Manage Messages"},
+	{discordgo.PermissionEmbedLinks, "Embed Links"},
+	{discordgo.PermissionAttachFiles, "Attach Files"},
+	{discordgo.PermissionReadMessageHistory, "Read Message History"},
+	{discordgo.PermissionMentionEveryone, "Mention Everyone"},
+	{discordgo.PermissionUseExternalEmojis, "Use External Emojis"},
+	{discordgo.PermissionVoiceConnect, "Connect"},
+	{discordgo.PermissionVoiceSpeak, "Speak"},
+	{discordgo.PermissionVoiceMuteMembers, "Mute Members"},
+	{discordgo.PermissionVoiceDeafenMembers, "Deafen Members"},
+	{discordgo.PermissionVoiceMoveMembers, "Move Members"},
+	{discordgo.PermissionVoiceUseVAD, "Use Voice Activity"},
+	{discordgo.PermissionChangeNickname, "Change Nickname"},
+	{discordgo.PermissionManageNicknames, "Manage Nicknames"},
+	{discordgo.PermissionManageRoles, "Manage Roles"},
+	{discordgo.PermissionManageWebhooks, "Manage Webhooks"},
+	{discordgo.PermissionManageEmojis, "Manage Emojis"},
+}
+
+// PermissionNames returns human-readable names of the permissions in the set.
+func PermissionNames(perms int) []string {
+	var names []string
+	for _, p := range permissionNames {
+		if perms&p.Permission != 0 {
+			names = append(names, p.Name)
+		}
+	}
+	return names
+}
+
+// FormatPermissions returns human-readable comma separated list of the permissions in the set.
+func FormatPermissions(perms int) string {
+	return strings.Join(PermissionNames(perms), ", ")
+}
+
+// missingPermissions returns the permissions from the required set that are missing in the actual one.
+func missingPermissions(actual int, required int) int {
+	return required &^ actual
+}
+
+// botPermissions returns the permissions bot has in the Request channel.
+func (sg *Instance) botPermissions(req *Request) (perms int, err error) {
+	err = traceStage(req, "bot_permission_check", func() (err error) {
+		perms, err = sg.ChannelPermissions(sg.Self.ID, req.Channel.ID)
+		return
+	})
+	return
+}
+
+// checkBotPermissions makes sure bot has the permissions required by the command in the Request channel.
+// BotPermissionError is returned if any of them is missing. Direct messages are not checked.
+func (sg *Instance) checkBotPermissions(req *Request) error {
+	if req.Command.BotPermissionsRequired == 0 || req.Channel.GuildID == "" {
+		return nil
+	}
+	perms, err := sg.botPermissions(req)
+	if err != nil {
+		return err
+	}
+	if missing := missingPermissions(perms, req.Command.BotPermissionsRequired); missing != 0 {
+		return NewBotPermissionError(missing)
+	}
+	return nil
+}
+
+// requiredPermissions returns the permissions bot needs to send the Response into the guild channel.
+func (resp *Response) requiredPermissions() int {
+	perms := discordgo.PermissionReadMessages | discordgo.PermissionSendMessages
+	if resp.Embed != nil {
+		perms |= discordgo.PermissionEmbedLinks
+	}
+	if len(resp.Files) > 0 {
+		perms |= discordgo.PermissionAttachFiles
+	}
+	return perms
+}
+
+// deliver sends the Response into the Request channel or, if bot is not able to write there, to the user DM.
+func (resp *Response) deliver() (*discordgo.Message, error) {
+	req := resp.Request
+	if req.Channel != nil && req.Channel.GuildID != "" {
+		perms, err := req.Sugo.botPermissions(req)
+		if err == nil && missingPermissions(perms, resp.requiredPermissions()) != 0 {
+			return resp.SendDM()
+		}
+	}
+	return resp.Send()
+}
@@ -0,0 +1,393 @@
+package sugo
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"strconv"
+	"time"
+)
+
+// policiesNamespace is the storage namespace policies keep their counters in.
+const policiesNamespace = "sugo.policies"
+
+// TimezoneSetting is the name of the setting containing the IANA time zone of the guild (or channel), e.g.
+// "Europe/Kyiv". Policies use it to tell the local time.
+const TimezoneSetting = "sugo.timezone"
+
+// Policy decides whether the command can be executed. Policies are evaluated after the permission checks, right
+// before the command execution.
+type Policy interface {
+	// Allow returns nil if Request is allowed to execute the command and the error otherwise. It's usually
+	// ThrottledError with the time command becomes available again.
+	Allow(req *Request) error
+}
+
+// PolicyReleaser is implemented by the policies that reserve something when they allow the Request, e.g. a use of
+// the quota. Release is called if the command is not executed successfully after all: one of the policies evaluated
+// later denied the Request or the command failed.
+type PolicyReleaser interface {
+	Release(req *Request) error
+}
+
+// policyValidator is implemented by the policies that can be misconfigured. Commands with such policies are not
+// allowed to be added.
+type policyValidator interface {
+	validate() error
+}
+
+// PolicyFunc is an adapter to use ordinary functions as policies.
+type PolicyFunc func(req *Request) error
+
+// Allow implements Policy interface.
+func (f PolicyFunc) Allow(req *Request) error {
+	return f(req)
+}
+
+// policies returns policies of the Request command and of all of it's parents, outermost first. There are none if
+// owner is configured to bypass cooldowns.
+func (req *Request) policies() []Policy {
+	if req.Bypasses(OwnerBypassCooldowns) {
+		return nil
+	}
+	var policies []Policy
+	for cmd := req.Command; cmd != nil; cmd = cmd.parent {
+		policies = append(append([]Policy{}, cmd.Policies...), policies...)
+	}
+	return policies
+}
+
+// checkPolicies evaluates policies of the command and of all of it's parents, outermost first. If one of them denies
+// the Request, the ones that allowed it are released.
+func (sg *Instance) checkPolicies(req *Request) error {
+	policies := req.policies()
+	for i, policy := range policies {
+		if err := policy.Allow(req); err != nil {
+			sg.releasePolicies(req, policies[:i])
+			return err
+		}
+	}
+	return nil
+}
+
+// releasePolicies releases the given policies as the command is not executed after all. The Request has already
+// failed by then, so errors are only reported.
+func (sg *Instance) releasePolicies(req *Request, policies []Policy) {
+	for _, policy := range policies {
+		if releaser, ok := policy.(PolicyReleaser); ok {
+			if err := releaser.Release(req); err != nil {
+				sg.HandleError(req, errors.Wrap(err, "unable to release policy"))
+			}
+		}
+	}
+}
+
+// validatePolicies makes sure policies of the command and of all of it's subcommands are configured properly.
+func (c *Command) validatePolicies() error {
+	for _, policy := range c.Policies {
+		if v, ok := policy.(policyValidator); ok {
+			if err := v.validate(); err != nil {
+				return errors.Wrap(err, "invalid policy of command "+c.GetPath())
+			}
+		}
+	}
+	for _, subCmd := range c.SubCommands {
+		if err := subCmd.validatePolicies(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Location returns the time zone of the Request guild or channel as per TimezoneSetting. UTC is returned if it's not
+// set or invalid.
+func (req *Request) Location() *time.Location {
+	location, err := time.LoadLocation(req.Setting(TimezoneSetting).String())
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
+
+// registerTimezoneSetting registers TimezoneSetting.
+func (sg *Instance) registerTimezoneSetting() {
+	sg.RegisterSetting(Setting{
+		Name:        TimezoneSetting,
+		Description: "time zone, e.g. Europe/Kyiv",
+		Type:        SettingString,
+		Default:     "UTC",
+		Validate: func(value interface{}) error {
+			_, err := time.LoadLocation(value.(string))
+			return err
+		},
+	})
+}
+
+// QuotaScope is what the quota is counted per.
+type QuotaScope string
+
+const (
+	// QuotaPerGuild counts the quota per guild (per DM channel outside of guilds).
+	QuotaPerGuild QuotaScope = "guild"
+	// QuotaPerChannel counts the quota per channel.
+	QuotaPerChannel QuotaScope = "channel"
+	// QuotaPerUser counts the quota per user.
+	QuotaPerUser QuotaScope = "user"
+)
+
+// QuotaPolicy limits the amount of the command uses per time window. The use is reserved atomically when the Request
+// is allowed and released if the command is not executed successfully, so only successful executions count. Counters
+// are kept in the bot Storage.
+type QuotaPolicy struct {
+	// Limit is the amount of uses allowed per window, it must be positive.
+	Limit int
+	// Window is the duration of the window, windows are aligned to the midnight in the guild (or channel) time zone
+	// as per TimezoneSetting. Window has to either divide a day or be a multiple of days. Defaults to 24 hours.
+	Window time.Duration
+	// Per is what the quota is counted per. Defaults to QuotaPerGuild.
+	Per QuotaScope
+}
+
+// quotaCounter is the stored state of the quota.
+type quotaCounter struct {
+	WindowStart int64 `json:"window_start"`
+	Count       int   `json:"count"`
+}
+
+// validate implements policyValidator interface.
+func (p *QuotaPolicy) validate() error {
+	if p.Limit <= 0 {
+		return errors.New("quota limit must be positive")
+	}
+	window := p.window()
+	if (24*time.Hour)%window != 0 && window%(24*time.Hour) != 0 {
+		return errors.New("quota window must either divide a day or be a multiple of days")
+	}
+	switch p.per() {
+	case QuotaPerGuild, QuotaPerChannel, QuotaPerUser:
+		return nil
+	}
+	return errors.New("unknown quota scope: " + string(p.Per))
+}
+
+// window returns the duration of the quota window.
+func (p *QuotaPolicy) window() time.Duration {
+	if p.Window <= 0 {
+		return 24 * time.Hour
+	}
+	return p.Window
+}
+
+// per returns what the quota is counted per.
+func (p *QuotaPolicy) per() QuotaScope {
+	if p.Per == "" {
+		return QuotaPerGuild
+	}
+	return p.Per
+}
+
+// key returns the storage key of the quota counter of the Request.
+func (p *QuotaPolicy) key(req *Request) string {
+	var scopeID string
+	switch p.per() {
+	case QuotaPerChannel:
+		scopeID = req.Channel.ID
+	case QuotaPerUser:
+		scopeID = req.Message.Author.ID
+	default:
+		scopeID = req.Channel.GuildID
+		if scopeID == "" {
+			scopeID = req.Channel.ID
+		}
+	}
+	return "quota/" + req.Command.GetPath() + "/" + string(p.per()) + "/" + scopeID
+}
+
+// errQuotaExceeded is returned from the counter update to abort it when there are no uses left.
+var errQuotaExceeded = errors.New("quota exceeded")
+
+// errQuotaUnchanged is returned from the counter update to abort it when there is nothing to change.
+var errQuotaUnchanged = errors.New("quota unchanged")
+
+// updateCounter atomically updates the counter of the current window of the Request. Counter expires along with the
+// window.
+func (p *QuotaPolicy) updateCounter(req *Request, fn func(counter *quotaCounter) error) (time.Time, error) {
+	start, end := quotaWindow(time.Now().In(req.Location()), p.window())
+	store := req.Sugo.Store(policiesNamespace)
+	err := store.Update(p.key(req), time.Until(end), func(value []byte, ok bool) ([]byte, error) {
+		var counter quotaCounter
+		if ok {
+			if err := json.Unmarshal(value, &counter); err != nil {
+				return nil, errors.Wrap(err, "unable to decode quota counter")
+			}
+		}
+		if counter.WindowStart != start.Unix() {
+			counter = quotaCounter{WindowStart: start.Unix()}
+		}
+		if err := fn(&counter); err != nil {
+			return nil, err
+		}
+		return json.Marshal(counter)
+	})
+	return end, err
+}
+
+// Allow implements Policy interface. It reserves the use of the quota.
+func (p *QuotaPolicy) Allow(req *Request) error {
+	end, err := p.updateCounter(req, func(counter *quotaCounter) error {
+		if counter.Count >= p.Limit {
+			return errQuotaExceeded
+		}
+		counter.Count++
+		return nil
+	})
+	if err == errQuotaExceeded {
+		return NewThrottledError(
+			"this command can only be used "+strconv.Itoa(p.Limit)+" times per "+formatWindow(p.window()),
+			end,
+		)
+	}
+	if err != nil {
+		return errors.Wrap(err, "unable to count quota")
+	}
+	return nil
+}
+
+// Release implements PolicyReleaser interface. It gives the reserved use of the quota back.
+func (p *QuotaPolicy) Release(req *Request) error {
+	_, err := p.updateCounter(req, func(counter *quotaCounter) error {
+		if counter.Count == 0 {
+			return errQuotaUnchanged
+		}
+		counter.Count--
+		return nil
+	})
+	if err != nil && err != errQuotaUnchanged {
+		return errors.Wrap(err, "unable to release quota")
+	}
+	return nil
+}
+
+// quotaWindow returns the start and the end of the quota window the given time belongs to. Windows are aligned to
+// the midnight of the time location, multiple day windows are counted from the 1st of January 1970.
+func quotaWindow(t time.Time, window time.Duration) (start time.Time, end time.Time) {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	if window < 24*time.Hour {
+		start = midnight.Add(t.Sub(midnight) / window * window)
+		return start, start.Add(window)
+	}
+	days := int(window / (24 * time.Hour))
+	day := int(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).Unix() / (24 * 60 * 60))
+	start = time.Date(1970, 1, 1+day-day%days, 0, 0, 0, 0, t.Location())
+	return start, time.Date(start.Year(), start.Month(), start.Day()+days, 0, 0, 0, 0, t.Location())
+}
+
+// formatWindow returns human-readable duration of the quota window.
+func formatWindow(window time.Duration) string {
+	switch {
+	case window == 24*time.Hour:
+		return "day"
+	case window == time.Hour:
+		return "hour"
+	case window%(24*time.Hour) == 0:
+		return fmt.Sprintf("%d days", window/(24*time.Hour))
+	}
+	return window.String()
+}
+
+// SchedulePolicy allows the command during the certain hours only. Time is taken in the guild (or channel) time
+// zone as per TimezoneSetting.
+type SchedulePolicy struct {
+	// From and To are the start and the end of the allowed time of the day as the duration since midnight, e.g.
+	// 9*time.Hour and 18*time.Hour. If From is after To the allowed time spans midnight, if they are equal the whole
+	// day starting at From is allowed.
+	From time.Duration
+	To   time.Duration
+	// Weekdays the command is allowed on. Every day if empty.
+	Weekdays []time.Weekday
+}
+
+// validate implements policyValidator interface.
+func (p *SchedulePolicy) validate() error {
+	if p.From < 0 || p.From >= 24*time.Hour {
+		return errors.New("schedule start must be within a day")
+	}
+	if p.To < 0 || p.To > 24*time.Hour {
+		return errors.New("schedule end must be within a day")
+	}
+	for _, weekday := range p.Weekdays {
+		if weekday < time.Sunday || weekday > time.Saturday {
+			return errors.New("unknown schedule weekday: " + strconv.Itoa(int(weekday)))
+		}
+	}
+	return nil
+}
+
+// allowedAt returns true if the command is allowed at the given local time.
+func (p *SchedulePolicy) allowedAt(t time.Time) bool {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	sinceMidnight := t.Sub(midnight)
+	day := t.Weekday()
+
+	var inWindow bool
+	if p.From == p.To {
+		// Window lasts the whole day starting at From.
+		inWindow = true
+		if sinceMidnight < p.From {
+			day = (day + 6) % 7
+		}
+	} else if p.From < p.To {
+		inWindow = sinceMidnight >= p.From && sinceMidnight < p.To
+	} else {
+		// Window spans midnight, the part after midnight belongs to the previous day.
+		if sinceMidnight >= p.From {
+			inWindow = true
+		} else if sinceMidnight < p.To {
+			inWindow = true
+			day = (day + 6) % 7
+		}
+	}
+	if !inWindow {
+		return false
+	}
+	if len(p.Weekdays) == 0 {
+		return true
+	}
+	for _, weekday := range p.Weekdays {
+		if weekday == day {
+			return true
+		}
+	}
+	return false
+}
+
+// nextAllowed returns the next time the command becomes allowed after the given local time. Zero time is returned
+// if it never does.
+func (p *SchedulePolicy) nextAllowed(t time.Time) time.Time {
+	// Allowed windows start at From, so it's enough to check the starts of the next 8 days.
+	for i := 0; i <= 7; i++ {
+		day := t.AddDate(0, 0, i)
+		start := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, t.Location()).Add(p.From)
+		if start.After(t) && p.allowedAt(start) {
+			return start
+		}
+	}
+	return time.Time{}
+}
+
+// Allow implements Policy interface.
+func (p *SchedulePolicy) Allow(req *Request) error {
+	location := req.Location()
+	now := time.Now().In(location)
+	if p.allowedAt(now) {
+		return nil
+	}
+	message := fmt.Sprintf("this command is only available from %s to %s (%s)",
+		formatTimeOfDay(p.From), formatTimeOfDay(p.To), location)
+	return NewThrottledError(message, p.nextAllowed(now))
+}
+
+// formatTimeOfDay formats duration since midnight as the time of the day.
+func formatTimeOfDay(d time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(d.Hours())%24, int(d.Minutes())%60)
+}
@@ -0,0 +1,317 @@
+package sugo
+
+import (
+	"context"
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// at returns the time of the given day of January 2024 (1st is Monday) in the given location.
+func at(day int, hour int, min int, loc *time.Location) time.Time {
+	return time.Date(2024, 1, day, hour, min, 0, 0, loc)
+}
+
+func TestSchedulePolicyAllowedAt(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy SchedulePolicy
+		t      time.Time
+		want   bool
+	}{
+		{"within day window", SchedulePolicy{From: 9 * time.Hour, To: 18 * time.Hour}, at(1, 12, 0, time.UTC), true},
+		{"at day window start", SchedulePolicy{From: 9 * time.Hour, To: 18 * time.Hour}, at(1, 9, 0, time.UTC), true},
+		{"at day window end", SchedulePolicy{From: 9 * time.Hour, To: 18 * time.Hour}, at(1, 18, 0, time.UTC), false},
+		{"before day window", SchedulePolicy{From: 9 * time.Hour, To: 18 * time.Hour}, at(1, 8, 59, time.UTC), false},
+		{"night window before midnight", SchedulePolicy{From: 22 * time.Hour, To: 6 * time.Hour}, at(1, 23, 0, time.UTC), true},
+		{"night window after midnight", SchedulePolicy{From: 22 * time.Hour, To: 6 * time.Hour}, at(2, 5, 0, time.UTC), true},
+		{"outside night window", SchedulePolicy{From: 22 * time.Hour, To: 6 * time.Hour}, at(2, 12, 0, time.UTC), false},
+		{
+			"night window after midnight belongs to previous weekday",
+			SchedulePolicy{From: 22 * time.Hour, To: 6 * time.Hour, Weekdays: []time.Weekday{time.Friday}},
+			at(6, 2, 0, time.UTC), // Saturday.
+			true,
+		},
+		{
+			"night window after midnight of allowed weekday",
+			SchedulePolicy{From: 22 * time.Hour, To: 6 * time.Hour, Weekdays: []time.Weekday{time.Friday}},
+			at(5, 2, 0, time.UTC), // Friday.
+			false,
+		},
+		{
+			"weekday not allowed",
+			SchedulePolicy{From: 9 * time.Hour, To: 18 * time.Hour, Weekdays: []time.Weekday{time.Saturday}},
+			at(1, 12, 0, time.UTC),
+			false,
+		},
+		{"equal bounds allow whole day", SchedulePolicy{From: 5 * time.Hour, To: 5 * time.Hour}, at(1, 3, 0, time.UTC), true},
+		{
+			"equal bounds before From belong to previous weekday",
+			SchedulePolicy{From: 5 * time.Hour, To: 5 * time.Hour, Weekdays: []time.Weekday{time.Monday}},
+			at(2, 4, 0, time.UTC), // Tuesday.
+			true,
+		},
+		{
+			"equal bounds before From of allowed weekday",
+			SchedulePolicy{From: 5 * time.Hour, To: 5 * time.Hour, Weekdays: []time.Weekday{time.Monday}},
+			at(1, 4, 0, time.UTC), // Monday.
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.allowedAt(tt.t); got != tt.want {
+				t.Errorf("allowedAt(%s) = %v, want %v", tt.t, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSchedulePolicyNextAllowed(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy SchedulePolicy
+		t      time.Time
+		want   time.Time
+	}{
+		{"later today", SchedulePolicy{From: 9 * time.Hour, To: 18 * time.Hour}, at(1, 7, 0, time.UTC), at(1, 9, 0, time.UTC)},
+		{"tomorrow", SchedulePolicy{From: 9 * time.Hour, To: 18 * time.Hour}, at(1, 19, 0, time.UTC), at(2, 9, 0, time.UTC)},
+		{"night window", SchedulePolicy{From: 22 * time.Hour, To: 6 * time.Hour}, at(1, 12, 0, time.UTC), at(1, 22, 0, time.UTC)},
+		{
+			"next weekday",
+			SchedulePolicy{From: 9 * time.Hour, To: 18 * time.Hour, Weekdays: []time.Weekday{time.Saturday}},
+			at(1, 12, 0, time.UTC),
+			at(6, 9, 0, time.UTC),
+		},
+		{
+			"same weekday next week",
+			SchedulePolicy{From: 9 * time.Hour, To: 18 * time.Hour, Weekdays: []time.Weekday{time.Monday}},
+			at(1, 19, 0, time.UTC),
+			at(8, 9, 0, time.UTC),
+		},
+		{
+			"night window of the previous weekday is over",
+			SchedulePolicy{From: 22 * time.Hour, To: 6 * time.Hour, Weekdays: []time.Weekday{time.Friday}},
+			at(6, 7, 0, time.UTC), // Saturday.
+			at(12, 22, 0, time.UTC),
+		},
+		{
+			"equal bounds",
+			SchedulePolicy{From: 5 * time.Hour, To: 5 * time.Hour, Weekdays: []time.Weekday{time.Wednesday}},
+			at(1, 12, 0, time.UTC),
+			at(3, 5, 0, time.UTC),
+		},
+		{"never", SchedulePolicy{From: 9 * time.Hour, To: 18 * time.Hour, Weekdays: []time.Weekday{7}}, at(1, 12, 0, time.UTC), time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.nextAllowed(tt.t); !got.Equal(tt.want) {
+				t.Errorf("nextAllowed(%s) = %s, want %s", tt.t, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatWindow(t *testing.T) {
+	tests := []struct {
+		window time.Duration
+		want   string
+	}{
+		{24 * time.Hour, "day"},
+		{time.Hour, "hour"},
+		{48 * time.Hour, "2 days"},
+		{7 * 24 * time.Hour, "7 days"},
+		{30 * time.Minute, "30m0s"},
+		{6 * time.Hour, "6h0m0s"},
+	}
+	for _, tt := range tests {
+		if got := formatWindow(tt.window); got != tt.want {
+			t.Errorf("formatWindow(%s) = %q, want %q", tt.window, got, tt.want)
+		}
+	}
+}
+
+func TestQuotaWindow(t *testing.T) {
+	zone := time.FixedZone("UTC+2", 2*60*60)
+	tests := []struct {
+		name      string
+		t         time.Time
+		window    time.Duration
+		wantStart time.Time
+		wantEnd   time.Time
+	}{
+		{"day in UTC", at(2, 15, 0, time.UTC), 24 * time.Hour, at(2, 0, 0, time.UTC), at(3, 0, 0, time.UTC)},
+		{"day aligned to local midnight", at(2, 1, 0, zone), 24 * time.Hour, at(2, 0, 0, zone), at(3, 0, 0, zone)},
+		{"hours", at(2, 15, 30, zone), 6 * time.Hour, at(2, 12, 0, zone), at(2, 18, 0, zone)},
+		// Multiple day windows are counted from Thursday, 1st of January 1970.
+		{"days", at(2, 15, 0, zone), 7 * 24 * time.Hour, at(-3, 0, 0, zone), at(4, 0, 0, zone)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end := quotaWindow(tt.t, tt.window)
+			if !start.Equal(tt.wantStart) || !end.Equal(tt.wantEnd) {
+				t.Errorf("quotaWindow(%s, %s) = %s, %s, want %s, %s", tt.t, tt.window, start, end, tt.wantStart, tt.wantEnd)
+			}
+		})
+	}
+}
+
+func TestQuotaPolicyValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  *QuotaPolicy
+		wantErr bool
+	}{
+		{"defaults", &QuotaPolicy{Limit: 1}, false},
+		{"zero limit", &QuotaPolicy{}, true},
+		{"negative limit", &QuotaPolicy{Limit: -1}, true},
+		{"window dividing a day", &QuotaPolicy{Limit: 1, Window: 8 * time.Hour}, false},
+		{"multiple days window", &QuotaPolicy{Limit: 1, Window: 72 * time.Hour}, false},
+		{"unaligned window", &QuotaPolicy{Limit: 1, Window: 36 * time.Hour}, true},
+		{"unknown scope", &QuotaPolicy{Limit: 1, Per: "role"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.policy.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// quotaCount returns the amount of quota uses counted for the Request.
+func quotaCount(t *testing.T, quota *QuotaPolicy, req *Request) int {
+	t.Helper()
+	var counter quotaCounter
+	if _, err := req.Sugo.Store(policiesNamespace).GetJSON(quota.key(req), &counter); err != nil {
+		t.Fatalf("unable to get quota counter: %v", err)
+	}
+	return counter.Count
+}
+
+// quotaRequest makes the Request of the command with the given policies.
+func quotaRequest(sg *Instance, cmd *Command) *Request {
+	sg.AddCommand(cmd)
+	return &Request{
+		ID:      "request",
+		Ctx:     context.Background(),
+		Sugo:    sg,
+		Message: &discordgo.Message{Author: &discordgo.User{ID: "user"}},
+		Channel: &discordgo.Channel{ID: "channel", GuildID: "guild"},
+		Command: cmd,
+	}
+}
+
+func TestQuotaPolicyReleasedOnFailure(t *testing.T) {
+	sg := New()
+	quota := &QuotaPolicy{Limit: 1}
+	var deny, fail, panics bool
+	req := quotaRequest(sg, &Command{
+		Trigger: "quota",
+		Policies: []Policy{
+			quota,
+			PolicyFunc(func(req *Request) error {
+				if deny {
+					return NewUserError("denied")
+				}
+				return nil
+			}),
+		},
+		Execute: func(req *Request) (*Response, error) {
+			if panics {
+				panic("panicked")
+			}
+			if fail {
+				return nil, errors.New("failed")
+			}
+			return req.NewResponse(ResponseInfo, "", "done"), nil
+		},
+	})
+
+	steps := []struct {
+		name          string
+		deny          bool
+		fail          bool
+		panics        bool
+		wantErr       bool
+		wantThrottled bool
+		wantCount     int
+	}{
+		{"denied by later policy", true, false, false, true, false, 0},
+		{"execution failed", false, true, false, true, false, 0},
+		{"execution panicked", false, false, true, true, false, 0},
+		{"succeeded", false, false, false, false, false, 1},
+		{"quota exceeded", false, false, false, true, true, 1},
+	}
+	for _, step := range steps {
+		deny, fail, panics = step.deny, step.fail, step.panics
+		_, err := protect(sg.executeCommand)(req)
+		if (err != nil) != step.wantErr {
+			t.Fatalf("%s: executeCommand() error = %v, want error %v", step.name, err, step.wantErr)
+		}
+		if _, throttled := errors.Cause(err).(*ThrottledError); throttled != step.wantThrottled {
+			t.Fatalf("%s: executeCommand() error = %v, want throttled %v", step.name, err, step.wantThrottled)
+		}
+		if count := quotaCount(t, quota, req); count != step.wantCount {
+			t.Fatalf("%s: quota count = %d, want %d", step.name, count, step.wantCount)
+		}
+	}
+
+	if key := quota.key(req); key != "quota/quota/guild/guild" {
+		t.Errorf("key() = %q, want empty Per to count per guild", key)
+	}
+}
+
+func TestQuotaPolicyConcurrentUses(t *testing.T) {
+	const limit, uses = 5, 50
+	sg := New()
+	quota := &QuotaPolicy{Limit: limit}
+	req := quotaRequest(sg, &Command{Trigger: "quota", Policies: []Policy{quota}, Execute: func(req *Request) (*Response, error) {
+		return nil, nil
+	}})
+
+	var wg sync.WaitGroup
+	var allowed int32
+	for i := 0; i < uses; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if quota.Allow(req) == nil {
+				atomic.AddInt32(&allowed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if allowed != limit {
+		t.Errorf("%d uses allowed, want %d", allowed, limit)
+	}
+	if count := quotaCount(t, quota, req); count != limit {
+		t.Errorf("quota count = %d, want %d", count, limit)
+	}
+}
+
+func TestSchedulePolicyValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  *SchedulePolicy
+		wantErr bool
+	}{
+		{"day window", &SchedulePolicy{From: 9 * time.Hour, To: 18 * time.Hour}, false},
+		{"until midnight", &SchedulePolicy{From: 9 * time.Hour, To: 24 * time.Hour}, false},
+		{"negative start", &SchedulePolicy{From: -time.Hour, To: 18 * time.Hour}, true},
+		{"start at the end of the day", &SchedulePolicy{From: 24 * time.Hour, To: 18 * time.Hour}, true},
+		{"end past the day", &SchedulePolicy{From: 9 * time.Hour, To: 25 * time.Hour}, true},
+		{"unknown weekday", &SchedulePolicy{Weekdays: []time.Weekday{7}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.policy.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -2,18 +2,26 @@ package sugo
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"github.com/bwmarrin/discordgo"
 	"github.com/pkg/errors"
+	"time"
 )
 
 // Request contains message context data along with some helpers to retrieve more information.
 type Request struct {
+	// ID uniquely identifies the Request. It's the correlation ID of the errors that happen while processing it.
+	ID      string
 	Ctx     context.Context
 	Sugo    *Instance
 	Message *discordgo.Message
 	Channel *discordgo.Channel
 	Command *Command
 	Query   string
+
+	// started is the time Request processing was started at.
+	started time.Time
 }
 
 // GetGuild allows to retrieve *discordgo.Guild from Request. Will not work and will throw error for channels
@@ -49,3 +57,18 @@ func (req *Request) IsChannelDM() bool {
 
 	return false
 }
+
+// newRequestID generates random Request ID.
+func newRequestID() string {
+	return randomID()
+}
+
+// randomID generates random 16 symbols long hex ID.
+func randomID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		// Should never happen, but even then empty ID is better than no Request processing at all.
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
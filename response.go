@@ -11,6 +11,8 @@ type Response struct {
 	Text    string
 	Embed   *discordgo.MessageEmbed
 	Emoji   discordgo.Emoji
+	// Files are attached to the Response message if any.
+	Files []*discordgo.File
 }
 
 type responseType string
@@ -32,7 +34,18 @@ const (
 
 // ReactOk adds "ok" emoji to the command message.
 func (req *Request) AddReaction(reaction emoji) (err error) {
-	return req.Sugo.Session.MessageReactionAdd(req.Channel.ID, req.Message.ID, string(reaction))
+	return req.rest("message_reaction_add", func() error {
+		return req.Sugo.Session.MessageReactionAdd(req.Channel.ID, req.Message.ID, string(reaction))
+	})
+}
+
+// rest performs discord REST call within it's own tracing span and counts the call failures.
+func (req *Request) rest(operation string, call func() error) error {
+	err := traceStage(req, "rest "+operation, call)
+	if err != nil {
+		req.Sugo.metrics().RESTFailures.Inc(operation)
+	}
+	return err
 }
 
 // SimpleResponse creates a default embed response.
@@ -103,18 +116,48 @@ func (resp *Response) send(channelID string) (m *discordgo.Message, err error) {
 		return nil, errors.New("unable to send Response: empty Request provided")
 	}
 
+	// Responses with files attached are sent as a single complex message along with the Text or Embed.
+	if len(resp.Files) > 0 {
+		if err = resp.Request.rest("channel_message_send_complex", func() (err error) {
+			m, err = resp.Request.Sugo.Session.ChannelMessageSendComplex(channelID, &discordgo.MessageSend{
+				Content: resp.Text,
+				Embed:   resp.Embed,
+				Files:   resp.Files,
+			})
+			return
+		}); err != nil {
+			return
+		}
+		resp.Request.Sugo.metrics().ResponsesSent.Inc(string(resp.Type))
+		return
+	}
+
 	switch resp.Type {
 	case ResponsePlainText:
 		// Response is a plain text response, send it as a plain text.
-		return resp.Request.Sugo.Session.ChannelMessageSend(channelID, resp.Text)
+		if err = resp.Request.rest("channel_message_send", func() (err error) {
+			m, err = resp.Request.Sugo.Session.ChannelMessageSend(channelID, resp.Text)
+			return
+		}); err != nil {
+			return
+		}
 
 	case ResponseDefault, ResponseInfo, ResponseSuccess, ResponseWarning, ResponseDanger:
 		// If response if one of the embed types - send response as an embed.
-		return resp.Request.Sugo.Session.ChannelMessageSendEmbed(channelID, resp.Embed)
+		if err = resp.Request.rest("channel_message_send_embed", func() (err error) {
+			m, err = resp.Request.Sugo.Session.ChannelMessageSendEmbed(channelID, resp.Embed)
+			return
+		}); err != nil {
+			return
+		}
+
+	default:
+		// Report error if it's some kind of weird unknown response type.
+		return nil, errors.New("unknown response type")
 	}
 
-	// Report error if it's some kind of weird unknown response type.
-	return nil, errors.New("unknown response type")
+	resp.Request.Sugo.metrics().ResponsesSent.Inc(string(resp.Type))
+	return
 }
 
 // Send sends a Response into the channel Request was sent in.
@@ -128,7 +171,10 @@ func (resp *Response) Send() (m *discordgo.Message, err error) {
 // SendDM sends a Response to the user DirectMessages channel.
 func (resp *Response) SendDM() (m *discordgo.Message, err error) {
 	var channel *discordgo.Channel
-	if channel, err = resp.Request.Sugo.Session.UserChannelCreate(resp.Request.Message.Author.ID); err != nil {
+	if err = resp.Request.rest("user_channel_create", func() (err error) {
+		channel, err = resp.Request.Sugo.Session.UserChannelCreate(resp.Request.Message.Author.ID)
+		return
+	}); err != nil {
 		return
 	}
 	return resp.send(channel.ID)
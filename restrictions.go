@@ -0,0 +1,78 @@
+package sugo
+
+import (
+	"github.com/bwmarrin/discordgo"
+)
+
+// ChannelTypeGuildNews is the type of the guild news (announcement) channels. It's missing from discordgo.
+const ChannelTypeGuildNews discordgo.ChannelType = 5
+
+// Restriction is a kind of channel restriction command may have.
+type Restriction string
+
+const (
+	// RestrictionGuild is violated when RequireGuild command is used outside of guild text or news channels.
+	RestrictionGuild Restriction = "guild"
+	// RestrictionDM is violated when DMOnly command is used outside of direct messages.
+	RestrictionDM Restriction = "dm"
+	// RestrictionChannelType is violated when command is used in the channel of the type not in AllowedChannelTypes.
+	RestrictionChannelType Restriction = "channel_type"
+	// RestrictionNSFW is violated when NSFWOnly command is used outside of NSFW channels.
+	RestrictionNSFW Restriction = "nsfw"
+	// RestrictionChannel is violated when command is used in the channel that is blocked or not allowed.
+	RestrictionChannel Restriction = "channel"
+)
+
+// defaultRestrictionMessages contains the messages user gets when restriction is violated.
+var defaultRestrictionMessages = map[Restriction]string{
+	RestrictionGuild:       "this command can only be used in server channels",
+	RestrictionDM:          "this command can only be used in direct messages",
+	RestrictionChannelType: "this command can not be used in this kind of channel",
+	RestrictionNSFW:        "this command can only be used in NSFW channels",
+	RestrictionChannel:     "this command can not be used in this channel",
+}
+
+// restrictionMessage returns the message for the violated restriction of the command. Command messages take
+// precedence over the bot ones, default message is used if there are none.
+func (sg *Instance) restrictionMessage(c *Command, r Restriction) string {
+	if message, ok := c.RestrictionMessages[r]; ok {
+		return message
+	}
+	if message, ok := sg.RestrictionMessages[r]; ok {
+		return message
+	}
+	return defaultRestrictionMessages[r]
+}
+
+// violatedRestriction returns the channel restriction of the command violated by the Request if any.
+func (c *Command) violatedRestriction(req *Request) (Restriction, bool) {
+	channel := req.Channel
+	isDM := channel.Type == discordgo.ChannelTypeDM || channel.Type == discordgo.ChannelTypeGroupDM
+
+	if c.RequireGuild && channel.Type != discordgo.ChannelTypeGuildText && channel.Type != ChannelTypeGuildNews {
+		return RestrictionGuild, true
+	}
+	if c.DMOnly && !isDM {
+		return RestrictionDM, true
+	}
+	if len(c.AllowedChannelTypes) > 0 {
+		allowed := false
+		for _, channelType := range c.AllowedChannelTypes {
+			if channel.Type == channelType {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return RestrictionChannelType, true
+		}
+	}
+	if c.NSFWOnly && !channel.NSFW {
+		return RestrictionNSFW, true
+	}
+	if containsString(c.BlockedChannels, channel.ID) ||
+		(len(c.AllowedChannels) > 0 && !containsString(c.AllowedChannels, channel.ID)) {
+		return RestrictionChannel, true
+	}
+	return "", false
+}
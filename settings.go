@@ -0,0 +1,469 @@
+package sugo
+
+import (
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// settingsNamespace is the storage namespace settings values are kept in.
+const settingsNamespace = "sugo.settings"
+
+// SettingType is the type of the setting value.
+type SettingType string
+
+const (
+	// SettingBool is a boolean setting, value is bool.
+	SettingBool SettingType = "bool"
+	// SettingInt is an integer setting, value is int.
+	SettingInt SettingType = "int"
+	// SettingString is a string setting, value is string.
+	SettingString SettingType = "string"
+	// SettingDuration is a duration setting, value is time.Duration.
+	SettingDuration SettingType = "duration"
+	// SettingRole is a guild role setting, value is the role ID string.
+	SettingRole SettingType = "role"
+	// SettingChannel is a channel setting, value is the channel ID string.
+	SettingChannel SettingType = "channel"
+)
+
+// SettingScope is the level setting value is set at. Values are resolved from the most specific scope to the least
+// specific one: channel, guild, global and then the default value.
+type SettingScope string
+
+const (
+	// SettingScopeDefault means value is the setting default.
+	SettingScopeDefault SettingScope = "default"
+	// SettingScopeGlobal means value is set for all the guilds.
+	SettingScopeGlobal SettingScope = "global"
+	// SettingScopeGuild means value is set for the guild.
+	SettingScopeGuild SettingScope = "guild"
+	// SettingScopeChannel means value is set for the channel.
+	SettingScopeChannel SettingScope = "channel"
+)
+
+// Setting describes the setting modules can use.
+type Setting struct {
+	// Name uniquely identifies the setting, e.g. "tags.max_length".
+	Name string
+	// Description should contain short setting description.
+	Description string
+	// Type is the type of the setting value.
+	Type SettingType
+	// Default is the value used if setting is not set at any scope. It must be of the type matching the Type.
+	Default interface{}
+	// Validate is called with the parsed value before it's set. Optional.
+	Validate func(value interface{}) error
+}
+
+// SettingValue is the resolved setting value.
+type SettingValue struct {
+	// Setting is the setting the value belongs to.
+	Setting *Setting
+	// Scope is the scope value was resolved at.
+	Scope SettingScope
+	// Value is the value itself, it's type matches the setting Type.
+	Value interface{}
+}
+
+// Bool returns the value as bool. False is returned if setting is not a SettingBool.
+func (v SettingValue) Bool() bool {
+	b, _ := v.Value.(bool)
+	return b
+}
+
+// Int returns the value as int. Zero is returned if setting is not a SettingInt.
+func (v SettingValue) Int() int {
+	i, _ := v.Value.(int)
+	return i
+}
+
+// Duration returns the value as time.Duration. Zero is returned if setting is not a SettingDuration.
+func (v SettingValue) Duration() time.Duration {
+	d, _ := v.Value.(time.Duration)
+	return d
+}
+
+// String returns the value as string. Role and channel settings values are IDs.
+func (v SettingValue) String() string {
+	s, _ := v.Value.(string)
+	return s
+}
+
+// Display returns human-readable value representation, role and channel values are mentions.
+func (v SettingValue) Display() string {
+	if v.Setting == nil {
+		return ""
+	}
+	return formatSettingValue(v.Setting.Type, v.Value)
+}
+
+// RegisterSetting registers the setting. Registering setting with the same name twice or with the default value of
+// the wrong type is a programming error, so it's fatal.
+func (sg *Instance) RegisterSetting(s Setting) {
+	if _, ok := sg.settings[s.Name]; ok {
+		log.Fatal("setting is already registered: " + s.Name)
+	}
+	if s.Name == "" || strings.ContainsAny(s.Name, " /") {
+		log.Fatal("invalid setting name: " + s.Name)
+	}
+	if _, err := encodeSettingValue(s.Type, s.Default); err != nil {
+		log.Fatal(errors.Wrap(err, "invalid default value of setting "+s.Name))
+	}
+
+	if sg.settings == nil {
+		sg.settings = map[string]*Setting{}
+	}
+	sg.settings[s.Name] = &s
+}
+
+// Settings returns all the registered settings sorted by name.
+func (sg *Instance) Settings() []*Setting {
+	var settings []*Setting
+	for _, s := range sg.settings {
+		settings = append(settings, s)
+	}
+	sort.Slice(settings, func(i, j int) bool {
+		return settings[i].Name < settings[j].Name
+	})
+	return settings
+}
+
+// settingKey returns the storage key of the setting value at the given scope.
+func settingKey(scope SettingScope, scopeID string, name string) string {
+	if scope == SettingScopeGlobal {
+		return "global/" + name
+	}
+	return string(scope) + "/" + scopeID + "/" + name
+}
+
+// getSetting returns the registered setting by name.
+func (sg *Instance) getSetting(name string) (*Setting, error) {
+	s, ok := sg.settings[name]
+	if !ok {
+		return nil, NewNotFoundError("setting " + name)
+	}
+	return s, nil
+}
+
+// ResolveSetting resolves the setting value for the given guild and channel. Either of IDs can be empty.
+func (sg *Instance) ResolveSetting(guildID string, channelID string, name string) (SettingValue, error) {
+	s, err := sg.getSetting(name)
+	if err != nil {
+		return SettingValue{}, err
+	}
+
+	store := sg.Store(settingsNamespace)
+	scopes := []struct {
+		scope SettingScope
+		id    string
+	}{
+		{SettingScopeChannel, channelID},
+		{SettingScopeGuild, guildID},
+		{SettingScopeGlobal, ""},
+	}
+	for _, scope := range scopes {
+		if scope.scope != SettingScopeGlobal && scope.id == "" {
+			continue
+		}
+		raw, ok, err := store.Get(settingKey(scope.scope, scope.id, name))
+		if err != nil {
+			return SettingValue{}, errors.Wrap(err, "unable to get setting "+name)
+		}
+		if ok {
+			value, err := decodeSettingValue(s.Type, string(raw))
+			if err != nil {
+				return SettingValue{}, errors.Wrap(err, "unable to decode setting "+name)
+			}
+			return SettingValue{Setting: s, Scope: scope.scope, Value: value}, nil
+		}
+	}
+
+	return SettingValue{Setting: s, Scope: SettingScopeDefault, Value: s.Default}, nil
+}
+
+// SetSetting validates the value and sets it at the given scope. scopeID is the guild or channel ID and is ignored
+// for the global scope.
+func (sg *Instance) SetSetting(scope SettingScope, scopeID string, name string, value interface{}) error {
+	s, err := sg.getSetting(name)
+	if err != nil {
+		return err
+	}
+	if s.Validate != nil {
+		if err = s.Validate(value); err != nil {
+			return NewUserWarning(err.Error())
+		}
+	}
+	raw, err := encodeSettingValue(s.Type, value)
+	if err != nil {
+		return NewUserWarning(err.Error())
+	}
+	return sg.Store(settingsNamespace).Set(settingKey(scope, scopeID, name), []byte(raw), 0)
+}
+
+// ResetSetting removes the value set at the given scope, so it's inherited from the less specific scope again.
+func (sg *Instance) ResetSetting(scope SettingScope, scopeID string, name string) error {
+	if _, err := sg.getSetting(name); err != nil {
+		return err
+	}
+	return sg.Store(settingsNamespace).Delete(settingKey(scope, scopeID, name))
+}
+
+// Setting resolves the setting value for the Request guild and channel. If setting can not be resolved - error is
+// handled and the default value is returned.
+func (req *Request) Setting(name string) SettingValue {
+	value, err := req.Sugo.ResolveSetting(req.Channel.GuildID, req.Channel.ID, name)
+	if err != nil {
+		req.Sugo.HandleError(req, errors.Wrap(err, "unable to resolve setting"))
+		if s, ok := req.Sugo.settings[name]; ok {
+			return SettingValue{Setting: s, Scope: SettingScopeDefault, Value: s.Default}
+		}
+	}
+	return value
+}
+
+// encodeSettingValue converts the typed value into it's stored representation.
+func encodeSettingValue(t SettingType, value interface{}) (string, error) {
+	switch t {
+	case SettingBool:
+		if b, ok := value.(bool); ok {
+			return strconv.FormatBool(b), nil
+		}
+	case SettingInt:
+		if i, ok := value.(int); ok {
+			return strconv.Itoa(i), nil
+		}
+	case SettingDuration:
+		if d, ok := value.(time.Duration); ok {
+			return d.String(), nil
+		}
+	case SettingString, SettingRole, SettingChannel:
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+	default:
+		return "", errors.New("unknown setting type: " + string(t))
+	}
+	return "", errors.New("value must be of " + string(t) + " type")
+}
+
+// decodeSettingValue converts the stored representation into the typed value.
+func decodeSettingValue(t SettingType, raw string) (interface{}, error) {
+	switch t {
+	case SettingBool:
+		return strconv.ParseBool(raw)
+	case SettingInt:
+		return strconv.Atoi(raw)
+	case SettingDuration:
+		return time.ParseDuration(raw)
+	case SettingString, SettingRole, SettingChannel:
+		return raw, nil
+	}
+	return nil, errors.New("unknown setting type: " + string(t))
+}
+
+// formatSettingValue returns human-readable value representation.
+func formatSettingValue(t SettingType, value interface{}) string {
+	raw, err := encodeSettingValue(t, value)
+	if err != nil {
+		return "?"
+	}
+	switch {
+	case t == SettingRole && raw != "":
+		return "<@&" + raw + ">"
+	case t == SettingChannel && raw != "":
+		return "<#" + raw + ">"
+	case t == SettingString:
+		return "`" + raw + "`"
+	case raw == "":
+		return "*not set*"
+	}
+	return raw
+}
+
+// parseSettingInput parses the value entered by the user in the guild.
+func (sg *Instance) parseSettingInput(guildID string, t SettingType, input string) (interface{}, error) {
+	input = strings.TrimSpace(input)
+	switch t {
+	case SettingBool:
+		switch strings.ToLower(input) {
+		case "true", "yes", "on", "enable", "enabled", "1":
+			return true, nil
+		case "false", "no", "off", "disable", "disabled", "0":
+			return false, nil
+		}
+		return nil, NewUserWarning("value must be either yes or no")
+	case SettingInt:
+		i, err := strconv.Atoi(input)
+		if err != nil {
+			return nil, NewUserWarning("value must be an integer")
+		}
+		return i, nil
+	case SettingDuration:
+		d, err := time.ParseDuration(input)
+		if err != nil {
+			return nil, NewUserWarning("value must be a duration, e.g. 1h30m")
+		}
+		return d, nil
+	case SettingString:
+		return input, nil
+	case SettingRole:
+		return sg.parseRole(guildID, input)
+	case SettingChannel:
+		if channelID := parseChannelID(input); channelID != "" {
+			return channelID, nil
+		}
+		return nil, NewUserWarning("value must be a channel mention")
+	}
+	return nil, errors.New("unknown setting type: " + string(t))
+}
+
+// parseRole returns role ID by role mention, ID or name.
+func (sg *Instance) parseRole(guildID string, input string) (string, error) {
+	if strings.HasPrefix(input, "<@&") && strings.HasSuffix(input, ">") {
+		return strings.TrimSuffix(strings.TrimPrefix(input, "<@&"), ">"), nil
+	}
+
+	guild, err := sg.Session.State.Guild(guildID)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to get guild")
+	}
+	for _, role := range guild.Roles {
+		if role.ID == input || strings.EqualFold(role.Name, input) {
+			return role.ID, nil
+		}
+	}
+	return "", NewNotFoundError("role " + input)
+}
+
+// parseChannelID extracts channel ID from the channel mention. Empty string is returned if input is not a channel
+// mention.
+func parseChannelID(input string) string {
+	if strings.HasPrefix(input, "<#") && strings.HasSuffix(input, ">") {
+		return strings.TrimSuffix(strings.TrimPrefix(input, "<#"), ">")
+	}
+	return ""
+}
+
+// splitSettingScope splits the command parameters into the setting parameters and the channel scope: if the last
+// parameter is a channel mention (and it's not the only value) - setting is to be changed for that channel only.
+// Channel must belong to the Request guild.
+func splitSettingScope(req *Request, params []string, minParams int) ([]string, SettingScope, string, error) {
+	if len(params) > minParams {
+		if channelID := parseChannelID(params[len(params)-1]); channelID != "" {
+			channel, err := req.Sugo.Session.State.Channel(channelID)
+			if err != nil || channel.GuildID != req.Channel.GuildID {
+				return nil, "", "", NewNotFoundError("channel")
+			}
+			return params[:len(params)-1], SettingScopeChannel, channelID, nil
+		}
+	}
+	return params, SettingScopeGuild, req.Channel.GuildID, nil
+}
+
+// SettingsCommand makes the command tree that allows guild administrators to list, get, set and reset settings for
+// the guild or for the specific channels. The command is not added automatically, use AddCommand to add it.
+func (sg *Instance) SettingsCommand() *Command {
+	return &Command{
+		Trigger:             "settings",
+		Description:         "manages bot settings of the guild",
+		PermissionsRequired: discordgo.PermissionManageServer,
+		RequireGuild:        true,
+		SubCommands: []*Command{
+			{
+				Trigger:     "list",
+				Description: "lists all the settings with their values in this channel",
+				Execute: func(req *Request) (*Response, error) {
+					var lines []string
+					for _, s := range sg.Settings() {
+						value, err := sg.ResolveSetting(req.Channel.GuildID, req.Channel.ID, s.Name)
+						if err != nil {
+							return nil, err
+						}
+						lines = append(lines, "**"+s.Name+"** = "+value.Display()+" ("+string(value.Scope)+")")
+					}
+					if len(lines) == 0 {
+						return req.NewResponse(ResponseInfo, "settings", "there are no settings"), nil
+					}
+					return req.NewResponse(ResponseInfo, "settings", truncate(strings.Join(lines, "\n"), 2000)), nil
+				},
+			},
+			{
+				Trigger:     "get",
+				Description: "shows the setting value and where it comes from: settings get <name> [#channel]",
+				HasParams:   true,
+				Execute: func(req *Request) (*Response, error) {
+					params, scope, scopeID, err := splitSettingScope(req, strings.Fields(req.Query), 1)
+					if err != nil {
+						return nil, err
+					}
+					if len(params) != 1 {
+						return nil, NewUserWarning("usage: settings get <name> [#channel]")
+					}
+					channelID := req.Channel.ID
+					if scope == SettingScopeChannel {
+						channelID = scopeID
+					}
+					value, err := sg.ResolveSetting(req.Channel.GuildID, channelID, params[0])
+					if err != nil {
+						return nil, err
+					}
+					text := "**" + value.Setting.Name + "** = " + value.Display() + " (" + string(value.Scope) + ")"
+					if value.Setting.Description != "" {
+						text += "\n" + value.Setting.Description
+					}
+					return req.NewResponse(ResponseInfo, "settings", text), nil
+				},
+			},
+			{
+				Trigger:     "set",
+				Description: "sets the setting value for the guild or the channel: settings set <name> <value> [#channel]",
+				HasParams:   true,
+				Execute: func(req *Request) (*Response, error) {
+					params, scope, scopeID, err := splitSettingScope(req, strings.Fields(req.Query), 2)
+					if err != nil {
+						return nil, err
+					}
+					if len(params) < 2 {
+						return nil, NewUserWarning("usage: settings set <name> <value> [#channel]")
+					}
+					s, err := sg.getSetting(params[0])
+					if err != nil {
+						return nil, err
+					}
+					value, err := sg.parseSettingInput(req.Channel.GuildID, s.Type, strings.Join(params[1:], " "))
+					if err != nil {
+						return nil, err
+					}
+					if err = sg.SetSetting(scope, scopeID, s.Name, value); err != nil {
+						return nil, err
+					}
+					return req.NewResponse(ResponseSuccess, "settings",
+						"**"+s.Name+"** = "+formatSettingValue(s.Type, value)+" ("+string(scope)+")"), nil
+				},
+			},
+			{
+				Trigger:     "reset",
+				Description: "resets the setting value for the guild or the channel: settings reset <name> [#channel]",
+				HasParams:   true,
+				Execute: func(req *Request) (*Response, error) {
+					params, scope, scopeID, err := splitSettingScope(req, strings.Fields(req.Query), 1)
+					if err != nil {
+						return nil, err
+					}
+					if len(params) != 1 {
+						return nil, NewUserWarning("usage: settings reset <name> [#channel]")
+					}
+					if err = sg.ResetSetting(scope, scopeID, params[0]); err != nil {
+						return nil, err
+					}
+					return req.NewResponse(ResponseSuccess, "settings", "**"+params[0]+"** is reset ("+string(scope)+")"), nil
+				},
+			},
+		},
+	}
+}
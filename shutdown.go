@@ -2,23 +2,26 @@ package sugo
 
 import (
 	"github.com/pkg/errors"
-	"os"
 )
 
-// Shutdown sends Shutdown signal to the bot's Shutdown channel.
+// Shutdown makes the running bot stop. It never blocks and does nothing if bot is not running.
 func (sg *Instance) Shutdown() {
-	sg.done <- os.Interrupt
+	sg.mu.Lock()
+	defer sg.mu.Unlock()
+	if sg.cancel != nil {
+		sg.cancel()
+	}
 }
 
-// teardown gracefully releases all resources and saves data before Shutdown.
+// shutdown gracefully releases all resources and saves data before Shutdown.
 func (sg *Instance) shutdown() (err error) {
 	// Run shutdown handlers.
-	for _, handler := range sg.shutdownHandlers {
-		if err := handler(sg); err != nil {
-			// In case of an error - we report the error and continue the shutdown process. Errors should not interrupt
-			// shutdown as we need to perform shutdown as cleanly as possible.
-			sg.HandleError(nil, errors.Wrap(err, "shutdown error"))
-		}
+	sg.runShutdownHandlers()
+
+	// Post the errors that were not reported yet.
+	if sg.ErrorReporter != nil {
+		sg.ErrorReporter.flush(sg)
+		sg.ErrorReporter.digest(sg)
 	}
 
 	// Close discord Session.
@@ -29,3 +32,14 @@ func (sg *Instance) shutdown() (err error) {
 	// No errors.
 	return
 }
+
+// runShutdownHandlers runs all the registered shutdown handlers.
+func (sg *Instance) runShutdownHandlers() {
+	for _, handler := range sg.shutdownHandlers {
+		if err := handler(sg); err != nil {
+			// In case of an error - we report the error and continue the shutdown process. Errors should not interrupt
+			// shutdown as we need to perform shutdown as cleanly as possible.
+			sg.HandleError(nil, errors.Wrap(err, "shutdown error"))
+		}
+	}
+}
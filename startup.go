@@ -1,18 +1,73 @@
 package sugo
 
 import (
+	"context"
 	"github.com/bwmarrin/discordgo"
 	"github.com/pkg/errors"
-	"log"
 	"os"
 	"os/signal"
+	"sync/atomic"
 	"syscall"
+	"time"
 )
 
-// Startup starts the bot up.
+// Startup starts the bot up and blocks until SIGINT or SIGTERM is received or Shutdown is called. Use Run instead if
+// you want to manage the bot lifetime and signal handling yourself.
 func (sg *Instance) Startup(token string) (err error) {
-	// Intitialize Shutdown channel.
-	sg.done = make(chan os.Signal, 1)
+	// Make the context that gets cancelled as soon as Shutdown signal arrives.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	return sg.Run(ctx, token)
+}
+
+// Run starts the bot up and blocks until given context is cancelled or Shutdown is called. Errors returned by the
+// startup handlers abort the startup and are returned to the caller.
+func (sg *Instance) Run(ctx context.Context, token string) (err error) {
+	// Make sure bot is not running already.
+	sg.mu.Lock()
+	if sg.state != StateCreated && sg.state != StateStopped {
+		state := sg.state
+		sg.mu.Unlock()
+		return errors.New("unable to run bot: bot is " + state.String())
+	}
+	sg.state = StateStarting
+	sg.ctx, sg.cancel = context.WithCancel(ctx)
+	runCtx := sg.ctx
+	sg.mu.Unlock()
+	atomic.StoreInt64(&sg.startedAt, time.Now().UnixNano())
+
+	// Make sure bot ends up stopped whatever happens.
+	defer func() {
+		sg.mu.Lock()
+		sg.cancel()
+		sg.ctx, sg.cancel = nil, nil
+		sg.state = StateStopped
+		sg.mu.Unlock()
+	}()
+
+	// Open the storage.
+	if err = sg.storage().Open(); err != nil {
+		return errors.Wrap(err, "unable to open storage")
+	}
+	defer func() {
+		if err := sg.storage().Close(); err != nil {
+			sg.HandleError(nil, errors.Wrap(err, "unable to close storage"))
+		}
+	}()
+
+	// Migrate the data before anything uses it.
+	if err = sg.runMigrations(); err != nil {
+		return errors.Wrap(err, "unable to migrate data")
+	}
+	if sg.MigrationsDryRun {
+		return nil
+	}
+
+	// Start HTTP server if configured.
+	if err = sg.startHTTPServer(runCtx); err != nil {
+		return err
+	}
 
 	// Create a new Discord Session using the provided bot token.
 	s, err := discordgo.New("Bot " + token)
@@ -30,16 +85,42 @@ func (sg *Instance) Startup(token string) (err error) {
 	}
 	sg.Self = self
 
+	// Get bot owners from the application if asked to.
+	if sg.OwnersFromApplication {
+		if err = sg.loadApplicationOwners(); err != nil {
+			return err
+		}
+	}
+
 	// Run startup handlers.
 	for _, handler := range sg.startupHandlers {
 		if err = handler(sg); err != nil {
-			// If there is any error - we stop the startup process and shut the bot down as there is not much sense
-			// to let bot finish the startup in an event of an error in startup handlers.
-			sg.Shutdown()
-			return
+			// If there is any error - we stop the startup process as there is not much sense to let bot finish the
+			// startup in an event of an error in startup handlers. Resources acquired by the handlers that did
+			// succeed still need to be released.
+			sg.runShutdownHandlers()
+			return errors.Wrap(err, "startup handler error")
 		}
 	}
 
+	// If bot was asked to stop while starting up - there is no need to connect at all.
+	if runCtx.Err() != nil {
+		sg.runShutdownHandlers()
+		return nil
+	}
+
+	// Keep track of the gateway connection for health checks.
+	sg.trackGateway()
+
+	// Keep cached permissions up to date.
+	sg.trackPermissionChanges()
+
+	// Leave blocked guilds on join if asked to.
+	sg.trackBlockedGuilds()
+
+	// Show maintenance in the bot status.
+	sg.trackMaintenanceStatus()
+
 	// Register callback for the messageCreate events.
 	sg.Session.AddHandler(func(s *discordgo.Session, mc *discordgo.MessageCreate) {
 		sg.onMessageCreate(mc.Message)
@@ -47,19 +128,24 @@ func (sg *Instance) Startup(token string) (err error) {
 
 	// Open the websocket and begin listening.
 	if err = sg.Session.Open(); err != nil {
+		sg.runShutdownHandlers()
 		return errors.Wrap(err, "unable to open discord connection")
 	}
 
-	// Notify that bot is now running.
-	log.Println("bot is now running, press ctrl+c to exit")
+	// Start posting error digests if error reporter is configured.
+	if sg.ErrorReporter != nil {
+		go sg.ErrorReporter.run(runCtx, sg)
+	}
 
-	// Register bot sg.done channel to receive Shutdown signals.
-	signal.Notify(sg.done, syscall.SIGINT, syscall.SIGTERM)
+	// Notify that bot is now running.
+	sg.setState(StateRunning)
+	sg.logger().Info("bot is now running", "version", VERSION, "user", sg.Self.ID)
 
-	// Wait for Shutdown signal to arrive.
-	<-sg.done
+	// Wait for the context to be cancelled.
+	<-runCtx.Done()
 
 	// Gracefully shut the bot down and return errors if any.
-	err = sg.shutdown()
-	return
+	sg.setState(StateStopping)
+	sg.logger().Info("bot is shutting down")
+	return sg.shutdown()
 }
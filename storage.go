@@ -0,0 +1,361 @@
+package sugo
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Storage is a namespaced key/value storage. Every module is supposed to use it's own namespace.
+type Storage interface {
+	// Open prepares the storage for use. It's called on bot startup.
+	Open() error
+	// Close flushes all the data and releases resources. It's called on bot shutdown.
+	Close() error
+	// Get returns the value by key. ok is false if there is no such key or it's expired.
+	Get(namespace string, key string) (value []byte, ok bool, err error)
+	// ExpiresAt returns the expiration time of the value by key, zero time means value never expires. ok is false if
+	// there is no such key or it's expired.
+	ExpiresAt(namespace string, key string) (expiresAt time.Time, ok bool, err error)
+	// Set sets the value by key. Zero ttl means value never expires.
+	Set(namespace string, key string, value []byte, ttl time.Duration) error
+	// Delete deletes the value by key. Deleting non-existent key is not an error.
+	Delete(namespace string, key string) error
+	// List returns all the values which keys start with the given prefix.
+	List(namespace string, prefix string) (map[string][]byte, error)
+	// Update atomically updates the value by key. fn receives the current value and returns the new one, nil value
+	// deletes the key. If fn returns an error - nothing is changed. ttl is applied to the new value the same way Set
+	// does it, KeepTTL preserves the current expiration time (values created with KeepTTL never expire).
+	Update(namespace string, key string, ttl time.Duration, fn func(value []byte, ok bool) ([]byte, error)) error
+	// Namespaces returns all the namespaces that have at least one key.
+	Namespaces() ([]string, error)
+}
+
+// KeepTTL makes Update preserve the expiration time of the value.
+const KeepTTL time.Duration = -1
+
+// storageEntry is the stored value along with it's expiration time.
+type storageEntry struct {
+	Value     []byte    `json:"value"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
+// expired returns true if entry is expired.
+func (e storageEntry) expired(now time.Time) bool {
+	return !e.ExpiresAt.IsZero() && !now.Before(e.ExpiresAt)
+}
+
+// storageData contains all the stored entries by namespace and key. Values are copied on the way in and out, so
+// callers can not change the stored data by modifying the slices. It's not safe for concurrent use.
+type storageData map[string]map[string]storageEntry
+
+// get returns the entry by key if it exists and is not expired.
+func (d storageData) get(namespace string, key string) (storageEntry, bool) {
+	entry, ok := d[namespace][key]
+	if !ok || entry.expired(time.Now()) {
+		return storageEntry{}, false
+	}
+	entry.Value = copyBytes(entry.Value)
+	return entry, true
+}
+
+// set sets the entry by key.
+func (d storageData) set(namespace string, key string, entry storageEntry) {
+	if d[namespace] == nil {
+		d[namespace] = map[string]storageEntry{}
+	}
+	entry.Value = copyBytes(entry.Value)
+	d[namespace][key] = entry
+}
+
+// delete deletes the entry by key.
+func (d storageData) delete(namespace string, key string) {
+	delete(d[namespace], key)
+	if len(d[namespace]) == 0 {
+		delete(d, namespace)
+	}
+}
+
+// list returns values of all the entries which keys start with the prefix.
+func (d storageData) list(namespace string, prefix string) map[string][]byte {
+	now := time.Now()
+	result := map[string][]byte{}
+	for key, entry := range d[namespace] {
+		if strings.HasPrefix(key, prefix) && !entry.expired(now) {
+			result[key] = copyBytes(entry.Value)
+		}
+	}
+	return result
+}
+
+// namespaces returns all the namespaces in sorted order.
+func (d storageData) namespaces() []string {
+	var namespaces []string
+	for namespace := range d {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
+
+// purge removes all the expired entries.
+func (d storageData) purge() {
+	now := time.Now()
+	for namespace, entries := range d {
+		for key, entry := range entries {
+			if entry.expired(now) {
+				d.delete(namespace, key)
+			}
+		}
+	}
+}
+
+// copyBytes returns the copy of the slice.
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
+
+// updatedExpiresAt returns the expiration time of the entry updated with the given ttl.
+func updatedExpiresAt(entry storageEntry, ttl time.Duration) time.Time {
+	if ttl == KeepTTL {
+		return entry.ExpiresAt
+	}
+	return expiresAt(ttl)
+}
+
+// expiresAt converts ttl to the expiration time.
+func expiresAt(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+// MemoryStorage keeps all the data in memory, so it's lost on bot restart.
+type MemoryStorage struct {
+	// mu guards data.
+	mu sync.Mutex
+	// data contains all the stored entries.
+	data storageData
+}
+
+// NewMemoryStorage creates empty MemoryStorage.
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{data: storageData{}}
+}
+
+// Open implements Storage interface.
+func (s *MemoryStorage) Open() error {
+	return nil
+}
+
+// Close implements Storage interface.
+func (s *MemoryStorage) Close() error {
+	return nil
+}
+
+// Get implements Storage interface.
+func (s *MemoryStorage) Get(namespace string, key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.data.get(namespace, key)
+	return entry.Value, ok, nil
+}
+
+// ExpiresAt implements Storage interface.
+func (s *MemoryStorage) ExpiresAt(namespace string, key string) (time.Time, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.data.get(namespace, key)
+	return entry.ExpiresAt, ok, nil
+}
+
+// Set implements Storage interface.
+func (s *MemoryStorage) Set(namespace string, key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data == nil {
+		s.data = storageData{}
+	}
+	s.data.set(namespace, key, storageEntry{Value: value, ExpiresAt: expiresAt(ttl)})
+	return nil
+}
+
+// Delete implements Storage interface.
+func (s *MemoryStorage) Delete(namespace string, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.delete(namespace, key)
+	return nil
+}
+
+// List implements Storage interface.
+func (s *MemoryStorage) List(namespace string, prefix string) (map[string][]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.list(namespace, prefix), nil
+}
+
+// Update implements Storage interface.
+func (s *MemoryStorage) Update(namespace string, key string, ttl time.Duration,
+	fn func(value []byte, ok bool) ([]byte, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data == nil {
+		s.data = storageData{}
+	}
+
+	entry, ok := s.data.get(namespace, key)
+	value, err := fn(entry.Value, ok)
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		s.data.delete(namespace, key)
+		return nil
+	}
+	s.data.set(namespace, key, storageEntry{Value: value, ExpiresAt: updatedExpiresAt(entry, ttl)})
+	return nil
+}
+
+// Namespaces implements Storage interface.
+func (s *MemoryStorage) Namespaces() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.purge()
+	return s.data.namespaces(), nil
+}
+
+// dumpStorage returns all the values of all the namespaces which keys start with the prefix.
+func dumpStorage(storage Storage, prefix string) (map[string]map[string][]byte, error) {
+	namespaces, err := storage.Namespaces()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list storage namespaces")
+	}
+
+	dump := map[string]map[string][]byte{}
+	for _, namespace := range namespaces {
+		values, err := storage.List(namespace, prefix)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to list "+namespace+" values")
+		}
+		if len(values) > 0 {
+			dump[namespace] = values
+		}
+	}
+	return dump, nil
+}
+
+// Store is a Storage scoped to a single namespace and (optionally) key prefix.
+type Store struct {
+	// storage is the underlying storage.
+	storage Storage
+	// namespace is the namespace of the store.
+	namespace string
+	// prefix is prepended to all the keys.
+	prefix string
+}
+
+// Namespace returns the namespace of the store.
+func (s *Store) Namespace() string {
+	return s.namespace
+}
+
+// Get returns the value by key. ok is false if there is no such key.
+func (s *Store) Get(key string) (value []byte, ok bool, err error) {
+	return s.storage.Get(s.namespace, s.prefix+key)
+}
+
+// Set sets the value by key. Zero ttl means value never expires.
+func (s *Store) Set(key string, value []byte, ttl time.Duration) error {
+	return s.storage.Set(s.namespace, s.prefix+key, value, ttl)
+}
+
+// Delete deletes the value by key.
+func (s *Store) Delete(key string) error {
+	return s.storage.Delete(s.namespace, s.prefix+key)
+}
+
+// List returns all the values which keys start with the given prefix. Keys are returned without the store prefix.
+func (s *Store) List(prefix string) (map[string][]byte, error) {
+	values, err := s.storage.List(s.namespace, s.prefix+prefix)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string][]byte, len(values))
+	for key, value := range values {
+		result[strings.TrimPrefix(key, s.prefix)] = value
+	}
+	return result, nil
+}
+
+// Update atomically updates the value by key. See Storage.Update for details.
+func (s *Store) Update(key string, ttl time.Duration, fn func(value []byte, ok bool) ([]byte, error)) error {
+	return s.storage.Update(s.namespace, s.prefix+key, ttl, fn)
+}
+
+// GetJSON unmarshals the value by key into v. ok is false if there is no such key.
+func (s *Store) GetJSON(key string, v interface{}) (ok bool, err error) {
+	value, ok, err := s.Get(key)
+	if err != nil || !ok {
+		return ok, err
+	}
+	if err = json.Unmarshal(value, v); err != nil {
+		return false, errors.Wrap(err, "unable to unmarshal stored value")
+	}
+	return true, nil
+}
+
+// SetJSON marshals v and stores it by key. Zero ttl means value never expires.
+func (s *Store) SetJSON(key string, v interface{}, ttl time.Duration) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrap(err, "unable to marshal value")
+	}
+	return s.Set(key, value, ttl)
+}
+
+// Store returns the store of the given namespace.
+func (sg *Instance) Store(namespace string) *Store {
+	return &Store{storage: sg.storage(), namespace: namespace}
+}
+
+// storage returns the Instance storage, in-memory one is created if there is none.
+func (sg *Instance) storage() Storage {
+	sg.mu.Lock()
+	defer sg.mu.Unlock()
+	if sg.Storage == nil {
+		sg.Storage = NewMemoryStorage()
+	}
+	return sg.Storage
+}
+
+// guildStorePrefix returns the key prefix of the guild scoped stores.
+func guildStorePrefix(guildID string) string {
+	return "guild/" + guildID + "/"
+}
+
+// GuildStore returns the store of the given namespace scoped to the Request guild. In DMs it's scoped to the channel
+// instead, as there is no guild.
+func (req *Request) GuildStore(namespace string) *Store {
+	store := req.Sugo.Store(namespace)
+	if req.Channel.GuildID == "" {
+		store.prefix = "channel/" + req.Channel.ID + "/"
+	} else {
+		store.prefix = guildStorePrefix(req.Channel.GuildID)
+	}
+	return store
+}
+
+// UserStore returns the store of the given namespace scoped to the Request author.
+func (req *Request) UserStore(namespace string) *Store {
+	store := req.Sugo.Store(namespace)
+	store.prefix = "user/" + req.Message.Author.ID + "/"
+	return store
+}
@@ -0,0 +1,304 @@
+package sugo
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// defaultCompactAfter is the amount of write-ahead log records after which the snapshot is rewritten.
+const defaultCompactAfter = 1000
+
+// walRecord is a single write-ahead log record.
+type walRecord struct {
+	// Op is either "set" or "delete".
+	Op        string    `json:"op"`
+	Namespace string    `json:"ns"`
+	Key       string    `json:"key"`
+	Value     []byte    `json:"value,omitempty"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
+// FileStorage keeps all the data in memory and persists it into the local file. Every change is appended to the
+// write-ahead log (Path + ".wal") and synced to disk before it's applied, so no acknowledged change is lost on crash.
+// If the change can not be written or synced, the log is truncated back, so the change is not replayed later either.
+// If even that fails, the storage refuses any further changes until it's reopened. Periodically and on Close the
+// whole data is written to the snapshot file (Path) via atomic rename and the log is truncated.
+type FileStorage struct {
+	// Path is the path to the snapshot file.
+	Path string
+	// CompactAfter is the amount of log records after which the snapshot is rewritten. Defaults to 1000.
+	CompactAfter int
+
+	// mu guards the fields below.
+	mu sync.Mutex
+	// data contains all the stored entries.
+	data storageData
+	// wal is the write-ahead log file, it's nil if storage is not open.
+	wal *os.File
+	// walRecords is the amount of records in the write-ahead log.
+	walRecords int
+	// walSize is the size of the write-ahead log with all the records applied.
+	walSize int64
+	// failed is the reason storage refuses changes, the log may contain the change that was not applied.
+	failed error
+}
+
+// NewFileStorage creates FileStorage that persists data into the file at the given path.
+func NewFileStorage(path string) *FileStorage {
+	return &FileStorage{Path: path}
+}
+
+// walPath returns the path to the write-ahead log file.
+func (s *FileStorage) walPath() string {
+	return s.Path + ".wal"
+}
+
+// Open implements Storage interface. It loads the snapshot, replays the write-ahead log and compacts the data.
+func (s *FileStorage) Open() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Reopening is allowed.
+	if s.wal != nil {
+		_ = s.wal.Close()
+		s.wal = nil
+	}
+
+	s.data = storageData{}
+	s.failed = nil
+
+	// Load the snapshot.
+	snapshot, err := ioutil.ReadFile(s.Path)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "unable to read storage snapshot")
+	}
+	if len(snapshot) > 0 {
+		if err = json.Unmarshal(snapshot, &s.data); err != nil {
+			return errors.Wrap(err, "unable to parse storage snapshot")
+		}
+	}
+
+	// Replay the write-ahead log.
+	if err = s.replay(); err != nil {
+		return err
+	}
+
+	// Write everything into the fresh snapshot and start the new log.
+	return s.compact()
+}
+
+// replay applies all the write-ahead log records to the data. Corrupted tail of the log (the record that was being
+// written on crash) is ignored.
+func (s *FileStorage) replay() error {
+	file, err := os.Open(s.walPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "unable to open storage log")
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var record walRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			break
+		}
+		s.apply(record)
+	}
+	return nil
+}
+
+// apply applies the log record to the data.
+func (s *FileStorage) apply(record walRecord) {
+	switch record.Op {
+	case "set":
+		s.data.set(record.Namespace, record.Key, storageEntry{Value: record.Value, ExpiresAt: record.ExpiresAt})
+	case "delete":
+		s.data.delete(record.Namespace, record.Key)
+	}
+}
+
+// write appends the record to the write-ahead log, syncs it and applies it to the data.
+func (s *FileStorage) write(record walRecord) error {
+	if s.wal == nil {
+		return errors.New("storage is not open")
+	}
+	if s.failed != nil {
+		return errors.Wrap(s.failed, "storage has failed, reopen it")
+	}
+
+	b, err := json.Marshal(record)
+	if err != nil {
+		return errors.Wrap(err, "unable to marshal storage log record")
+	}
+	n, err := s.wal.Write(append(b, '\n'))
+	if err != nil {
+		err = errors.Wrap(err, "unable to write storage log")
+	} else if err = s.wal.Sync(); err != nil {
+		err = errors.Wrap(err, "unable to sync storage log")
+	}
+	if err != nil {
+		// The record may still reach the disk, so it's removed to keep the log in line with the data.
+		if truncateErr := s.truncate(); truncateErr != nil {
+			s.failed = truncateErr
+		}
+		return err
+	}
+	s.apply(record)
+
+	// Rewrite the snapshot if log is too long.
+	s.walSize += int64(n)
+	s.walRecords++
+	compactAfter := s.CompactAfter
+	if compactAfter <= 0 {
+		compactAfter = defaultCompactAfter
+	}
+	if s.walRecords >= compactAfter {
+		return s.compact()
+	}
+	return nil
+}
+
+// truncate removes the records that were not applied from the write-ahead log.
+func (s *FileStorage) truncate() error {
+	if err := s.wal.Truncate(s.walSize); err != nil {
+		return errors.Wrap(err, "unable to truncate storage log")
+	}
+	if err := s.wal.Sync(); err != nil {
+		return errors.Wrap(err, "unable to sync storage log")
+	}
+	return nil
+}
+
+// compact writes all the data into the snapshot and truncates the write-ahead log.
+func (s *FileStorage) compact() error {
+	s.data.purge()
+	b, err := json.Marshal(s.data)
+	if err != nil {
+		return errors.Wrap(err, "unable to marshal storage snapshot")
+	}
+
+	// Write the snapshot into the temporary file first and then atomically replace the old one with it, so there is
+	// always either old or new snapshot on disk.
+	tmp, err := ioutil.TempFile(filepath.Dir(s.Path), filepath.Base(s.Path)+".tmp")
+	if err != nil {
+		return errors.Wrap(err, "unable to create storage snapshot")
+	}
+	if _, err = tmp.Write(b); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.Path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return errors.Wrap(err, "unable to write storage snapshot")
+	}
+
+	// Snapshot contains everything now, so the log can be started over.
+	if s.wal != nil {
+		_ = s.wal.Close()
+	}
+	if s.wal, err = os.OpenFile(s.walPath(), os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_APPEND, 0600); err != nil {
+		return errors.Wrap(err, "unable to open storage log")
+	}
+	s.walRecords = 0
+	s.walSize = 0
+	return nil
+}
+
+// Close implements Storage interface. It writes the snapshot and closes the write-ahead log.
+func (s *FileStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.wal == nil {
+		return nil
+	}
+	if err := s.compact(); err != nil {
+		return err
+	}
+	err := s.wal.Close()
+	s.wal = nil
+	return err
+}
+
+// Get implements Storage interface.
+func (s *FileStorage) Get(namespace string, key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.data.get(namespace, key)
+	return entry.Value, ok, nil
+}
+
+// ExpiresAt implements Storage interface.
+func (s *FileStorage) ExpiresAt(namespace string, key string) (time.Time, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.data.get(namespace, key)
+	return entry.ExpiresAt, ok, nil
+}
+
+// Set implements Storage interface.
+func (s *FileStorage) Set(namespace string, key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.write(walRecord{Op: "set", Namespace: namespace, Key: key, Value: value, ExpiresAt: expiresAt(ttl)})
+}
+
+// Delete implements Storage interface.
+func (s *FileStorage) Delete(namespace string, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.write(walRecord{Op: "delete", Namespace: namespace, Key: key})
+}
+
+// List implements Storage interface.
+func (s *FileStorage) List(namespace string, prefix string) (map[string][]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.list(namespace, prefix), nil
+}
+
+// Update implements Storage interface.
+func (s *FileStorage) Update(namespace string, key string, ttl time.Duration,
+	fn func(value []byte, ok bool) ([]byte, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.data.get(namespace, key)
+	value, err := fn(entry.Value, ok)
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		return s.write(walRecord{Op: "delete", Namespace: namespace, Key: key})
+	}
+	return s.write(walRecord{
+		Op:        "set",
+		Namespace: namespace,
+		Key:       key,
+		Value:     value,
+		ExpiresAt: updatedExpiresAt(entry, ttl),
+	})
+}
+
+// Namespaces implements Storage interface.
+func (s *FileStorage) Namespaces() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.purge()
+	return s.data.namespaces(), nil
+}
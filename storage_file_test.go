@@ -0,0 +1,226 @@
+package sugo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openFileStorage opens the file storage at the given path failing the test on error.
+func openFileStorage(t *testing.T, path string) *FileStorage {
+	t.Helper()
+	s := NewFileStorage(path)
+	if err := s.Open(); err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	return s
+}
+
+// assertValue makes sure the storage contains the value by key, empty want means there should be no value.
+func assertValue(t *testing.T, s Storage, key string, want string) {
+	t.Helper()
+	value, ok, err := s.Get("ns", key)
+	if err != nil {
+		t.Fatalf("Get(%q) error = %v", key, err)
+	}
+	if want == "" {
+		if ok {
+			t.Fatalf("Get(%q) = %q, want no value", key, value)
+		}
+		return
+	}
+	if !ok || string(value) != want {
+		t.Fatalf("Get(%q) = %q, %v, want %q", key, value, ok, want)
+	}
+}
+
+func TestFileStorageReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bot.json")
+	s := openFileStorage(t, path)
+	if err := s.Set("ns", "a", []byte("1"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set("ns", "b", []byte("2"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("ns", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Update("ns", "c", KeepTTL, func(value []byte, ok bool) ([]byte, error) {
+		return []byte("3"), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	s = openFileStorage(t, path)
+	defer s.Close()
+	assertValue(t, s, "a", "1")
+	assertValue(t, s, "b", "")
+	assertValue(t, s, "c", "3")
+}
+
+func TestFileStorageReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bot.json")
+	s := openFileStorage(t, path)
+	if err := s.Set("ns", "a", []byte("1"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set("ns", "a", []byte("2"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// Storage is not closed, as if bot crashed: the changes are only in the log.
+	crashed := openFileStorage(t, path)
+	defer crashed.Close()
+	assertValue(t, crashed, "a", "2")
+}
+
+func TestFileStorageTornTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bot.json")
+	s := openFileStorage(t, path)
+	if err := s.Set("ns", "a", []byte("1"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// Crash in the middle of the record write.
+	wal, err := os.OpenFile(path+".wal", os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = wal.WriteString(`{"op":"set","ns":"ns","key":"b","val`); err != nil {
+		t.Fatal(err)
+	}
+	_ = wal.Close()
+
+	s = openFileStorage(t, path)
+	assertValue(t, s, "a", "1")
+	assertValue(t, s, "b", "")
+
+	// Torn record is dropped, so the log stays readable for the new records.
+	if err = s.Set("ns", "c", []byte("3"), 0); err != nil {
+		t.Fatal(err)
+	}
+	s = openFileStorage(t, path)
+	defer s.Close()
+	assertValue(t, s, "a", "1")
+	assertValue(t, s, "c", "3")
+}
+
+func TestFileStorageCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bot.json")
+	s := openFileStorage(t, path)
+	s.CompactAfter = 2
+	for _, key := range []string{"a", "b", "c"} {
+		if err := s.Set("ns", key, []byte(key), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Two records went into the snapshot, the third one is the only one in the log.
+	snapshot, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(snapshot), `"b"`) || strings.Contains(string(snapshot), `"c"`) {
+		t.Errorf("snapshot = %s, want a and b only", snapshot)
+	}
+	wal, err := ioutil.ReadFile(path + ".wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(wal), "\n"); lines != 1 {
+		t.Errorf("log contains %d records, want 1", lines)
+	}
+
+	crashed := openFileStorage(t, path)
+	defer crashed.Close()
+	for _, key := range []string{"a", "b", "c"} {
+		assertValue(t, crashed, key, key)
+	}
+}
+
+func TestFileStorageTTL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bot.json")
+	s := openFileStorage(t, path)
+	defer s.Close()
+
+	if err := s.Set("ns", "expiring", []byte("1"), 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set("ns", "kept", []byte("1"), 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set("ns", "extended", []byte("1"), 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	increment := func(value []byte, ok bool) ([]byte, error) {
+		return []byte("2"), nil
+	}
+	if err := s.Update("ns", "kept", KeepTTL, increment); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Update("ns", "extended", time.Hour, increment); err != nil {
+		t.Fatal(err)
+	}
+	assertValue(t, s, "expiring", "1")
+	assertValue(t, s, "kept", "2")
+
+	time.Sleep(100 * time.Millisecond)
+	assertValue(t, s, "expiring", "")
+	assertValue(t, s, "kept", "")
+	assertValue(t, s, "extended", "2")
+
+	// Expired values are not restored on reopen.
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s = openFileStorage(t, path)
+	assertValue(t, s, "expiring", "")
+	assertValue(t, s, "extended", "2")
+}
+
+func TestFileStorageFailedWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bot.json")
+	s := openFileStorage(t, path)
+	if err := s.Set("ns", "a", []byte("1"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// Neither the write nor the rollback can succeed now.
+	_ = s.wal.Close()
+	if err := s.Set("ns", "b", []byte("2"), 0); err == nil {
+		t.Fatal("Set() error = nil, want write error")
+	}
+	assertValue(t, s, "b", "")
+	if err := s.Set("ns", "c", []byte("3"), 0); err == nil || !strings.Contains(err.Error(), "reopen") {
+		t.Fatalf("Set() error = %v, want storage failed error", err)
+	}
+
+	s = openFileStorage(t, path)
+	defer s.Close()
+	assertValue(t, s, "a", "1")
+	assertValue(t, s, "b", "")
+	if err := s.Set("ns", "c", []byte("3"), 0); err != nil {
+		t.Fatalf("Set() after reopen error = %v", err)
+	}
+}
+
+func TestMemoryStorageUpdateTTL(t *testing.T) {
+	s := NewMemoryStorage()
+	if err := s.Set("ns", "a", []byte("1"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Update("ns", "a", time.Nanosecond, func(value []byte, ok bool) ([]byte, error) {
+		return []byte("2"), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	assertValue(t, s, "a", "")
+}
@@ -2,17 +2,18 @@
 package sugo
 
 import (
+	"context"
 	"github.com/bwmarrin/discordgo"
 	"github.com/pkg/errors"
 	"log"
-	"os"
+	"strings"
+	"sync"
+	"time"
 )
 
 // VERSION contains current version of the Instance framework.
 const VERSION = "0.6.2"
 
-type RequestMiddleware func(*Request) error
-type ResponseMiddleware func(*Response) error
 type startupHandler func(sg *Instance) (err error)
 type shutdownHandler func(sg *Instance) (err error)
 
@@ -28,22 +29,109 @@ type Instance struct {
 	Self *discordgo.User
 	// RootCommand is a bot root meta-command.
 	RootCommand *Command
+	// Owners contains IDs of the bot owners.
+	Owners []string
+	// OwnersFromApplication makes bot add the application owner (or all of the application team members) to Owners
+	// on startup.
+	OwnersFromApplication bool
+	// MaintenanceMessage is shown to the users during the maintenance unless maintenance has it's own message.
+	MaintenanceMessage string
+	// MaintenanceAllowRoles contains IDs or names of the roles that are not affected by the maintenance.
+	MaintenanceAllowRoles []string
+	// AutoLeaveBlockedGuilds makes bot leave the guilds in the blocklist as soon as it joins them or they get blocked.
+	AutoLeaveBlockedGuilds bool
+	// ExplainDenials makes bot respond with the reason when user is not allowed to use the command they asked for.
+	// Such commands are silently ignored otherwise.
+	ExplainDenials bool
+	// RestrictionMessages override the messages user gets when command channel restrictions are violated.
+	RestrictionMessages map[Restriction]string
+	// OwnerBypass specifies the checks bot owners are exempt from.
+	OwnerBypass OwnerBypass
+	// GuildConfigNamespaces lists the storage namespaces guild configuration export and import work with. Settings,
+	// ACLs and commands enabled in the guild are included by default, modules should add their own namespaces.
+	GuildConfigNamespaces []string
+	// Storage is the persistent storage modules keep their data in. It's opened on startup and closed on shutdown.
+	// In-memory storage is used if not set.
+	Storage Storage
+	// MigrationsDryRun makes Run only report pending migrations and stop without starting the bot up.
+	MigrationsDryRun bool
+	// MigrationsBackupDir is the directory storage backup is written to before migrations are applied. Defaults to
+	// the current working directory.
+	MigrationsBackupDir string
 
 	// IsTriggered should return true if the bot is to react to command and false otherwise.
 	IsTriggered func(req *Request) (triggered bool)
 	// ErrorHandler is the function that receives and handles all the errors. Keep in mind that *Request can be nil
 	// if error handler is called outside of command request scope.
 	ErrorHandler func(req *Request, err error)
+	// ErrorRenderers make Responses out of the errors returned by commands and middlewares per response type. If there
+	// is no renderer for the response type - default one is used.
+	ErrorRenderers map[responseType]ErrorRenderer
+	// Logger receives all the bot log events. If not set - events of info level and above are written to stderr as
+	// JSON.
+	Logger Logger
+	// Metrics contains the bot metrics. They are exposed at /metrics of the HTTP server if HTTPAddr is set.
+	Metrics *Metrics
+	// HTTPAddr is the address local HTTP server listens on. Server is not started if HTTPAddr is empty.
+	HTTPAddr string
+	// AdminSecret enables admin routes of the HTTP server. Admin requests must carry it as a bearer token in the
+	// Authorization header.
+	AdminSecret string
+	// SpanExporter receives the tracing spans of the Requests if set.
+	SpanExporter SpanExporter
+	// UsageStats collects command usage statistics if set.
+	UsageStats *UsageStats
+	// AuditSink receives the audit entries for every command executed if set.
+	AuditSink AuditSink
+	// ErrorReporter posts internal errors into the discord channel if set.
+	ErrorReporter *ErrorReporter
+	// PanicLimit is the amount of panics within PanicWindow after which command gets disabled automatically. Zero
+	// value means commands are never disabled.
+	PanicLimit int
+	// PanicWindow is the time window PanicLimit is applied to. Zero value means panics are never forgotten.
+	PanicWindow time.Duration
 
-	// done is channel that receives Shutdown signals.
-	done chan os.Signal
+	// mu guards lifecycle related fields below.
+	mu sync.Mutex
+	// state is current lifecycle state of the bot.
+	state State
+	// ctx is the context bot is running with, it's nil if bot is not running.
+	ctx context.Context
+	// cancel cancels ctx and makes bot shut down.
+	cancel context.CancelFunc
 	// startupHandlers are executed sequentially one by one on bot startup.
 	startupHandlers []startupHandler
 	// shutdownHandlers are executed sequentially one by one on bot shutdown.
 	shutdownHandlers []shutdownHandler
 
-	requestMiddlewares  []RequestMiddleware
-	responseMiddlewares []ResponseMiddleware
+	// startedAt is the time bot was started at in unix nanoseconds.
+	startedAt int64
+	// gatewayConnected is set to 1 while bot is connected to the discord gateway.
+	gatewayConnected int32
+	// lastEventAt is the time of the last event received from the discord gateway in unix nanoseconds.
+	lastEventAt int64
+
+	// settings contains registered settings by name.
+	settings map[string]*Setting
+	// migrations contains registered data migrations by namespace.
+	migrations map[string][]Migration
+
+	// panics contains recent panic times per command.
+	panics map[*Command][]time.Time
+	// panicsMu guards panics.
+	panicsMu sync.Mutex
+	// middlewares contains global middlewares.
+	middlewares middlewareChain
+	// legacyMiddlewares counts middlewares added via deprecated adapters to give them unique names.
+	legacyMiddlewares int64
+	// permissions caches resolved user permissions.
+	permissions permissionCache
+	// statusMu guards status and maintenanceStatus.
+	statusMu sync.Mutex
+	// status is the presence status set with SetStatus, nil if it was never set.
+	status *botStatus
+	// maintenanceStatus is true while presence status shows the global maintenance.
+	maintenanceStatus bool
 }
 
 // New creates new bot instance.
@@ -51,6 +139,15 @@ func New() *Instance {
 	// Create our bot.
 	sugo := &Instance{}
 
+	// Initialize bot metrics.
+	sugo.Metrics = NewMetrics()
+
+	// Include built-in guild configuration into the guild configuration backups.
+	sugo.GuildConfigNamespaces = []string{settingsNamespace, aclNamespace, guildCommandsNamespace}
+
+	// Register built-in settings.
+	sugo.registerTimezoneSetting()
+
 	// Initialize bot root command.
 	sugo.RootCommand = &Command{}
 
@@ -71,16 +168,6 @@ func (sg *Instance) AddShutdownHandler(handler shutdownHandler) {
 	sg.shutdownHandlers = append(sg.shutdownHandlers, handler)
 }
 
-// AddRequestMiddleware adds request middleware.
-func (sg *Instance) AddRequestMiddleware(m RequestMiddleware) {
-	sg.requestMiddlewares = append(sg.requestMiddlewares, m)
-}
-
-// AddResponseMiddleware adds response middleware.
-func (sg *Instance) AddResponseMiddleware(m ResponseMiddleware) {
-	sg.responseMiddlewares = append(sg.responseMiddlewares, m)
-}
-
 // AddCommand adds command to the bot's commands list.
 func (sg *Instance) AddCommand(c *Command) {
 	// Validate command.
@@ -97,30 +184,58 @@ func (sg *Instance) AddCommand(c *Command) {
 	return
 }
 
-// hasPermissions calculates if user has all the necessary permissions.
-func (sg *Instance) hasPermissions(req *Request, requiredPerms int) (result bool) {
-	if requiredPerms != 0 {
-		// First of all - get the user perms.
-		actualPerms, err := sg.Session.State.UserChannelPermissions(req.Message.Author.ID, req.Channel.ID)
-		if err != nil {
-			sg.HandleError(nil, errors.Wrap(err, "user permissions retrieval failed"))
-			return false
-		}
+// missingUserPermissions returns the permissions from the required set the Request author does not have.
+func (sg *Instance) missingUserPermissions(req *Request, requiredPerms int) (int, error) {
+	// No permissions specified.
+	if requiredPerms == 0 {
+		return 0, nil
+	}
 
-		// Check if user has all the required permissions.
-		if (actualPerms | requiredPerms) == actualPerms {
-			return true
-		}
+	// First of all - get the user perms.
+	var actualPerms int
+	err := traceStage(req, "permission_check", func() (err error) {
+		actualPerms, err = sg.ChannelPermissions(req.Message.Author.ID, req.Channel.ID)
+		return
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "user permissions retrieval failed")
+	}
+
+	return missingPermissions(actualPerms, requiredPerms), nil
+}
 
-		// User does not have required permissions.
-		return false
+// IsOwner returns true if user with the given ID is one of the bot owners.
+func (sg *Instance) IsOwner(userID string) bool {
+	return containsString(sg.Owners, userID)
+}
+
+// Commands returns all the registered commands (including subcommands) depth-first.
+func (sg *Instance) Commands() []*Command {
+	var commands []*Command
+	var walk func(c *Command)
+	walk = func(c *Command) {
+		for _, subCmd := range c.SubCommands {
+			commands = append(commands, subCmd)
+			walk(subCmd)
+		}
 	}
+	walk(sg.RootCommand)
+	return commands
+}
 
-	// No permissions specified.
-	return true
+// GetCommandByPath returns the registered command with the given path or nil if there is none.
+func (sg *Instance) GetCommandByPath(path string) *Command {
+	path = strings.Join(strings.Fields(path), " ")
+	for _, cmd := range sg.Commands() {
+		if cmd.GetPath() == path {
+			return cmd
+		}
+	}
+	return nil
 }
 
-// FindCommand searches for the command in the modules registered.
+// FindCommand searches for the command in the modules registered. If the command user asked for can not be used in
+// the Request channel or by the user - the error carrying the reason is returned.
 func (sg *Instance) FindCommand(req *Request, q string) (*Command, error) {
 	var err error
 	var cmd *Command
@@ -130,6 +245,15 @@ func (sg *Instance) FindCommand(req *Request, q string) (*Command, error) {
 		return nil, err
 	}
 	if cmd != nil {
+		// Commands disabled in the guild are ignored.
+		disabled, err := sg.isDisabledInChannel(req.Channel, cmd)
+		if err != nil {
+			return nil, err
+		}
+		if disabled {
+			return nil, &commandDenial{Command: cmd, Reason: NewPermissionError("this command is disabled here")}
+		}
+
 		// Command found.
 		return cmd, nil
 	}
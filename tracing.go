@@ -0,0 +1,215 @@
+package sugo
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// Span describes the timed operation within the Request processing. Spans form a tree: every Request has the root
+// span, stages of the Request processing are it's children. All the Span methods are safe to be called on nil Span.
+type Span struct {
+	TraceID    string            `json:"trace_id"`
+	SpanID     string            `json:"span_id"`
+	ParentID   string            `json:"parent_id,omitempty"`
+	Name       string            `json:"name"`
+	Start      time.Time         `json:"start"`
+	Duration   time.Duration     `json:"duration"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Error      string            `json:"error,omitempty"`
+
+	// mu guards Attributes.
+	mu sync.Mutex
+	// exporter receives the span once it's ended.
+	exporter SpanExporter
+	// logger receives span export errors.
+	logger Logger
+}
+
+// SpanExporter receives ended spans.
+type SpanExporter interface {
+	Export(span *Span) error
+}
+
+// spanContextKey is the context key span is stored under.
+type spanContextKey struct{}
+
+// SpanFromContext returns the span stored in the context or nil if there is none.
+func SpanFromContext(ctx context.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+	span, _ := ctx.Value(spanContextKey{}).(*Span)
+	return span
+}
+
+// StartSpan starts the child span of the span stored in the context. If there is no span in the context (tracing is
+// not enabled) - nil span is returned, which is safe to be used.
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+
+	span := &Span{
+		TraceID:  parent.TraceID,
+		SpanID:   randomID(),
+		ParentID: parent.SpanID,
+		Name:     name,
+		Start:    time.Now(),
+		exporter: parent.exporter,
+		logger:   parent.logger,
+	}
+	return context.WithValue(ctx, spanContextKey{}, span), span
+}
+
+// startRootSpan starts the root span of the Request if span exporter is configured. Span starts at the time Request
+// processing started.
+func (sg *Instance) startRootSpan(req *Request) *Span {
+	if sg.SpanExporter == nil {
+		return nil
+	}
+
+	span := &Span{
+		TraceID:  req.ID,
+		SpanID:   randomID(),
+		Name:     "request",
+		Start:    req.started,
+		exporter: sg.SpanExporter,
+		logger:   sg.logger(),
+	}
+	req.Ctx = context.WithValue(req.Ctx, spanContextKey{}, span)
+	return span
+}
+
+// SetAttribute sets span attribute.
+func (s *Span) SetAttribute(key string, value string) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Attributes == nil {
+		s.Attributes = map[string]string{}
+	}
+	s.Attributes[key] = value
+}
+
+// End ends the span and exports it. Error (if any) is recorded into the span.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+
+	s.Duration = time.Since(s.Start)
+	if err != nil {
+		s.Error = err.Error()
+	}
+	if err := s.exporter.Export(s); err != nil {
+		s.logger.Error("unable to export span", "error", err, "trace_id", s.TraceID, "span", s.Name)
+	}
+}
+
+// recordStage records the Request processing stage that has already completed as a span.
+func recordStage(req *Request, name string, start time.Time, err error) {
+	_, span := StartSpan(req.Ctx, name)
+	if span == nil {
+		return
+	}
+	span.Start = start
+	span.End(err)
+}
+
+// traceStage runs the Request processing stage within it's own span. Request context is replaced with the span one
+// for the time of the stage, so nested stages become it's children.
+func traceStage(req *Request, name string, stage func() error) error {
+	ctx, span := StartSpan(req.Ctx, name)
+	if span == nil {
+		return stage()
+	}
+
+	parentCtx := req.Ctx
+	req.Ctx = ctx
+	defer func() {
+		req.Ctx = parentCtx
+	}()
+
+	err := stage()
+	span.End(err)
+	return err
+}
+
+// MemorySpanExporter keeps all the exported spans in memory. It's meant to be used in tests.
+type MemorySpanExporter struct {
+	// mu guards spans.
+	mu sync.Mutex
+	// spans contains exported spans.
+	spans []*Span
+}
+
+// Export implements SpanExporter interface.
+func (e *MemorySpanExporter) Export(span *Span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, span)
+	return nil
+}
+
+// Spans returns all the exported spans in order they were ended in.
+func (e *MemorySpanExporter) Spans() []*Span {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]*Span(nil), e.spans...)
+}
+
+// Reset forgets all the exported spans.
+func (e *MemorySpanExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = nil
+}
+
+// JSONFileSpanExporter appends exported spans to the file as JSON lines.
+type JSONFileSpanExporter struct {
+	// mu guards file.
+	mu sync.Mutex
+	// file is the file spans are appended to.
+	file *os.File
+}
+
+// NewJSONFileSpanExporter opens (or creates) the file spans are to be appended to.
+func NewJSONFileSpanExporter(path string) (*JSONFileSpanExporter, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open spans file")
+	}
+	return &JSONFileSpanExporter{file: file}, nil
+}
+
+// Export implements SpanExporter interface.
+func (e *JSONFileSpanExporter) Export(span *Span) error {
+	span.mu.Lock()
+	b, err := json.Marshal(span)
+	span.mu.Unlock()
+	if err != nil {
+		return errors.Wrap(err, "unable to marshal span")
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, err = e.file.Write(append(b, '\n')); err != nil {
+		return errors.Wrap(err, "unable to write span")
+	}
+	return nil
+}
+
+// Close closes the file.
+func (e *JSONFileSpanExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.file.Close()
+}
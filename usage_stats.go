@@ -0,0 +1,367 @@
+package sugo
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// usageDayFormat is the format of the usage statistics day.
+const usageDayFormat = "2006-01-02"
+
+// maxLatencySamples is the maximum amount of latency samples kept per usage record.
+const maxLatencySamples = 1000
+
+// UsageKey identifies usage statistics record.
+type UsageKey struct {
+	Command string `json:"command"`
+	GuildID string `json:"guild_id"`
+	// Day is the UTC day in YYYY-MM-DD format.
+	Day string `json:"day"`
+}
+
+// UsageRecord contains usage statistics of the command in the guild within a day.
+type UsageRecord struct {
+	UsageKey
+	// Count is the amount of command executions.
+	Count int `json:"count"`
+	// Errors is the amount of command executions that ended up with an error.
+	Errors int `json:"errors"`
+	// Users contains IDs of the users that executed the command.
+	Users []string `json:"users"`
+	// Latencies contains latency samples in milliseconds.
+	Latencies []float64 `json:"latencies"`
+}
+
+// copy returns the deep copy of the record.
+func (r *UsageRecord) copy() *UsageRecord {
+	copied := *r
+	copied.Users = append([]string(nil), r.Users...)
+	copied.Latencies = append([]float64(nil), r.Latencies...)
+	return &copied
+}
+
+// UsageStorage persists usage statistics records.
+type UsageStorage interface {
+	// Load returns the record by key or nil if there is none.
+	Load(key UsageKey) (*UsageRecord, error)
+	// Save saves the record.
+	Save(record *UsageRecord) error
+	// List returns all the records starting from the given day. If guildID is not empty - only the records of the
+	// given guild are returned.
+	List(since string, guildID string) ([]*UsageRecord, error)
+}
+
+// MemoryUsageStorage keeps usage statistics in memory. Records are copied on the way in and out.
+type MemoryUsageStorage struct {
+	// mu guards records.
+	mu sync.Mutex
+	// records contains records by key.
+	records map[UsageKey]*UsageRecord
+}
+
+// Load implements UsageStorage interface.
+func (s *MemoryUsageStorage) Load(key UsageKey) (*UsageRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if record, ok := s.records[key]; ok {
+		return record.copy(), nil
+	}
+	return nil, nil
+}
+
+// Save implements UsageStorage interface.
+func (s *MemoryUsageStorage) Save(record *UsageRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.records == nil {
+		s.records = map[UsageKey]*UsageRecord{}
+	}
+	s.records[record.UsageKey] = record.copy()
+	return nil
+}
+
+// List implements UsageStorage interface.
+func (s *MemoryUsageStorage) List(since string, guildID string) ([]*UsageRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var records []*UsageRecord
+	for key, record := range s.records {
+		if key.Day >= since && (guildID == "" || key.GuildID == guildID) {
+			records = append(records, record.copy())
+		}
+	}
+	return records, nil
+}
+
+// StoreUsageStorage persists usage statistics into the bot Store.
+type StoreUsageStorage struct {
+	// store is where records are kept.
+	store *Store
+}
+
+// NewStoreUsageStorage creates StoreUsageStorage that keeps records in the given store, e.g.
+// NewStoreUsageStorage(sg.Store("usage")).
+func NewStoreUsageStorage(store *Store) *StoreUsageStorage {
+	return &StoreUsageStorage{store: store}
+}
+
+// usageStoreKey returns the key record is stored by.
+func usageStoreKey(key UsageKey) string {
+	return key.Day + "/" + key.GuildID + "/" + key.Command
+}
+
+// Load implements UsageStorage interface.
+func (s *StoreUsageStorage) Load(key UsageKey) (*UsageRecord, error) {
+	var record UsageRecord
+	ok, err := s.store.GetJSON(usageStoreKey(key), &record)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// Save implements UsageStorage interface.
+func (s *StoreUsageStorage) Save(record *UsageRecord) error {
+	return s.store.SetJSON(usageStoreKey(record.UsageKey), record, 0)
+}
+
+// List implements UsageStorage interface.
+func (s *StoreUsageStorage) List(since string, guildID string) ([]*UsageRecord, error) {
+	values, err := s.store.List("")
+	if err != nil {
+		return nil, err
+	}
+	var records []*UsageRecord
+	for _, value := range values {
+		var record UsageRecord
+		if err := json.Unmarshal(value, &record); err != nil {
+			return nil, errors.Wrap(err, "unable to unmarshal usage record")
+		}
+		if record.Day >= since && (guildID == "" || record.GuildID == guildID) {
+			records = append(records, &record)
+		}
+	}
+	return records, nil
+}
+
+// UsageSummary is the usage statistics of the command aggregated over a period.
+type UsageSummary struct {
+	Command     string
+	Count       int
+	UniqueUsers int
+	ErrorRate   float64
+	P50         time.Duration
+	P95         time.Duration
+}
+
+// UsageStats collects command usage statistics.
+type UsageStats struct {
+	// mu serializes records updates.
+	mu sync.Mutex
+	// storage persists the records.
+	storage UsageStorage
+}
+
+// NewUsageStats creates UsageStats that persists the records into the given storage.
+func NewUsageStats(storage UsageStorage) *UsageStats {
+	return &UsageStats{storage: storage}
+}
+
+// Record registers the single command execution.
+func (u *UsageStats) Record(command string, guildID string, userID string, latency time.Duration, failed bool) error {
+	key := UsageKey{Command: command, GuildID: guildID, Day: time.Now().UTC().Format(usageDayFormat)}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	record, err := u.storage.Load(key)
+	if err != nil {
+		return errors.Wrap(err, "unable to load usage record")
+	}
+	if record == nil {
+		record = &UsageRecord{UsageKey: key}
+	}
+
+	record.Count++
+	if failed {
+		record.Errors++
+	}
+	if !containsString(record.Users, userID) {
+		record.Users = append(record.Users, userID)
+	}
+	// Keep the latest samples only.
+	record.Latencies = append(record.Latencies, float64(latency)/float64(time.Millisecond))
+	if len(record.Latencies) > maxLatencySamples {
+		record.Latencies = record.Latencies[len(record.Latencies)-maxLatencySamples:]
+	}
+
+	if err = u.storage.Save(record); err != nil {
+		return errors.Wrap(err, "unable to save usage record")
+	}
+	return nil
+}
+
+// Top returns the most used commands within the given amount of days. If guildID is empty - statistics for all the
+// guilds are aggregated.
+func (u *UsageStats) Top(guildID string, days int, limit int) ([]UsageSummary, error) {
+	since := time.Now().UTC().AddDate(0, 0, 1-days).Format(usageDayFormat)
+	records, err := u.storage.List(since, guildID)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list usage records")
+	}
+
+	// Aggregate records by command.
+	type aggregate struct {
+		count, errors int
+		users         map[string]bool
+		latencies     []float64
+	}
+	aggregates := map[string]*aggregate{}
+	for _, record := range records {
+		a, ok := aggregates[record.Command]
+		if !ok {
+			a = &aggregate{users: map[string]bool{}}
+			aggregates[record.Command] = a
+		}
+		a.count += record.Count
+		a.errors += record.Errors
+		for _, user := range record.Users {
+			a.users[user] = true
+		}
+		a.latencies = append(a.latencies, record.Latencies...)
+	}
+
+	var summaries []UsageSummary
+	for command, a := range aggregates {
+		sort.Float64s(a.latencies)
+		summaries = append(summaries, UsageSummary{
+			Command:     command,
+			Count:       a.count,
+			UniqueUsers: len(a.users),
+			ErrorRate:   float64(a.errors) / float64(a.count),
+			P50:         time.Duration(percentile(a.latencies, 0.5) * float64(time.Millisecond)),
+			P95:         time.Duration(percentile(a.latencies, 0.95) * float64(time.Millisecond)),
+		})
+	}
+
+	// Most used go first.
+	sort.Slice(summaries, func(i, j int) bool {
+		if summaries[i].Count != summaries[j].Count {
+			return summaries[i].Count > summaries[j].Count
+		}
+		return summaries[i].Command < summaries[j].Command
+	})
+	if limit > 0 && len(summaries) > limit {
+		summaries = summaries[:limit]
+	}
+	return summaries, nil
+}
+
+// percentile returns the nearest-rank percentile of sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(p*float64(len(sorted))+0.5) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+// containsString returns true if slice contains the string.
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// recordUsage records the Request command execution into usage statistics if they are configured.
+func (sg *Instance) recordUsage(req *Request, latency time.Duration, err error) {
+	if sg.UsageStats == nil || req.Command == nil {
+		return
+	}
+	if recordErr := sg.UsageStats.Record(req.Command.GetPath(), req.Channel.GuildID, req.Message.Author.ID, latency, err != nil); recordErr != nil {
+		sg.HandleError(req, errors.Wrap(recordErr, "unable to record usage"))
+	}
+}
+
+// StatsCommand makes the command that shows the most used commands of the guild. Bot owners can see the statistics
+// aggregated over all the guilds with "stats global". The command is not added automatically, use AddCommand to add
+// it.
+func (sg *Instance) StatsCommand() *Command {
+	const days, limit = 7, 15
+
+	render := func(req *Request, guildID string, title string) (*Response, error) {
+		if sg.UsageStats == nil {
+			return nil, NewUserError("usage statistics are not available")
+		}
+
+		summaries, err := sg.UsageStats.Top(guildID, days, limit)
+		if err != nil {
+			return nil, err
+		}
+		if len(summaries) == 0 {
+			return req.NewResponse(ResponseInfo, title, "no commands were used yet"), nil
+		}
+
+		// Render the table.
+		width := len("command")
+		for _, s := range summaries {
+			if len(s.Command) > width {
+				width = len(s.Command)
+			}
+		}
+		var b strings.Builder
+		b.WriteString("```\n")
+		fmt.Fprintf(&b, "%-*s %6s %6s %5s %7s %7s\n", width, "command", "uses", "users", "err%", "p50", "p95")
+		for _, s := range summaries {
+			fmt.Fprintf(&b, "%-*s %6d %6d %5s %7s %7s\n", width, s.Command, s.Count, s.UniqueUsers,
+				strconv.FormatFloat(s.ErrorRate*100, 'f', 1, 64), formatLatency(s.P50), formatLatency(s.P95))
+		}
+		b.WriteString("```")
+
+		return req.NewResponse(ResponseInfo, title, b.String()), nil
+	}
+
+	return &Command{
+		Trigger:     "stats",
+		Description: "shows the most used commands of the guild for the last " + strconv.Itoa(days) + " days",
+		Execute: func(req *Request) (*Response, error) {
+			if req.Channel.GuildID == "" {
+				return nil, NewUserWarning("guild statistics are only available in guilds")
+			}
+			return render(req, req.Channel.GuildID, "top commands of the guild")
+		},
+		SubCommands: []*Command{
+			{
+				Trigger:     "global",
+				Description: "shows the most used commands across all the guilds",
+				OwnerOnly:   true,
+				Execute: func(req *Request) (*Response, error) {
+					return render(req, "", "top commands")
+				},
+			},
+		},
+	}
+}
+
+// formatLatency formats latency in a compact way.
+func formatLatency(d time.Duration) string {
+	if d < time.Second {
+		return strconv.FormatInt(d.Milliseconds(), 10) + "ms"
+	}
+	return strconv.FormatFloat(d.Seconds(), 'f', 1, 64) + "s"
+}